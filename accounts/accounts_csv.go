@@ -0,0 +1,31 @@
+package accounts
+
+import (
+	"strconv"
+	"time"
+)
+
+// CSVHeader names the columns written by CSVRecord; the monthly sign-ups
+// are left out, they are in the JSON form only
+var CSVHeader = []string{
+	"report_id", "generated_at", "valid_records", "invalid_records",
+	"total", "active", "inactive", "signups_last_30_days",
+}
+
+// CSVRecord flattens the report into one row matching CSVHeader
+func (r *AccountsReport) CSVRecord() []string {
+	var generatedAt string
+	if r.GeneratedAt != nil {
+		generatedAt = r.GeneratedAt.UTC().Format(time.RFC3339)
+	}
+	var validRecords, invalidRecords string
+	if r.DataQuality != nil {
+		validRecords = strconv.Itoa(r.DataQuality.Valid)
+		invalidRecords = strconv.Itoa(r.DataQuality.Invalid)
+	}
+	return []string{
+		strconv.FormatInt(r.ReportID, 10), generatedAt, validRecords, invalidRecords,
+		strconv.Itoa(r.Total), strconv.Itoa(r.Active),
+		strconv.Itoa(r.Inactive), strconv.Itoa(r.SignupsLast30Days),
+	}
+}
@@ -0,0 +1,63 @@
+package accounts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github/godspeedkil/admin-report/report"
+)
+
+// schema stores one accounts report per row of accounts_reports, the
+// monthly sign-ups as a JSON document
+var schema = report.Schema[AccountsReport]{
+	Table: "accounts_reports",
+	Columns: []report.Column{
+		{Name: "total", Definition: "INT UNSIGNED"},
+		{Name: "active", Definition: "INT UNSIGNED"},
+		{Name: "inactive", Definition: "INT UNSIGNED"},
+		{Name: "signups_last_30_days", Definition: "INT UNSIGNED"},
+		{Name: "signups", Definition: "TEXT"},
+		{Name: "created_at", Definition: "DATETIME(3)"},
+		{Name: "data_quality", Definition: "TEXT", Added: true},
+	},
+	Values: accountsReportValues,
+	Scan: scanAccountsReport,
+	Metrics: []string{"total", "active", "inactive", "signups_last_30_days"},
+}
+
+func accountsReportValues(r *AccountsReport) []interface{} {
+	// a slice of plain structs always encodes
+	signups, _ := json.Marshal(r.Signups)
+	return []interface{}{r.Total, r.Active, r.Inactive, r.SignupsLast30Days,
+		string(signups), r.GeneratedAt, report.JSONColumn(r.DataQuality)}
+}
+
+func scanAccountsReport(s report.RowScanner) (*AccountsReport, error) {
+	var (
+		accountsReport	AccountsReport
+		signups			sql.NullString
+		createdAt		sql.NullTime
+		dataQuality		sql.NullString
+	)
+	if err := s.Scan(&accountsReport.ReportID, &accountsReport.Total,
+		&accountsReport.Active, &accountsReport.Inactive,
+		&accountsReport.SignupsLast30Days, &signups, &createdAt, &dataQuality); err != nil {
+		return nil, err
+	}
+
+	accountsReport.Signups = make([]SignupCount, 0)
+	if signups.Valid && signups.String != "" {
+		if err := json.Unmarshal([]byte(signups.String), &accountsReport.Signups); err != nil {
+			return nil, fmt.Errorf("could not decode signups: %v", err)
+		}
+	}
+	quality, err := report.ScanJSONColumn[report.DataQuality](dataQuality)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode data quality: %v", err)
+	}
+	accountsReport.DataQuality = quality
+	if createdAt.Valid {
+		accountsReport.GeneratedAt = &createdAt.Time
+	}
+	return &accountsReport, nil
+}
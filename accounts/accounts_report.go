@@ -0,0 +1,230 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/tasks"
+	"github/godspeedkil/admin-report/upstream"
+	"sync"
+	"time"
+)
+
+const (
+	ACCOUNTS_URL = "http://localhost:8002"
+	// sign-ups are bucketed per calendar month over this many months,
+	// the current one included
+	SIGNUP_MONTHS = 12
+	SIGNUP_RECENT_DAYS = 30
+)
+
+type Account struct {
+	UserID		string	`json:"_id"`
+	Name		string	`json:"name"`
+	Email		string	`json:"email"`
+	// unix seconds, like the dates of the tasks service
+	CreatedAt	int64	`json:"createdAt"`
+}
+
+// AccountActivity is an account together with how much it uses the habits
+// and tasks services
+type AccountActivity struct {
+	Account
+	Habits	int	`json:"habits"`
+	Tasks	int	`json:"tasks"`
+}
+
+// Active reports whether the account owns at least one habit or task
+func (a AccountActivity) Active() bool {
+	return a.Habits > 0 || a.Tasks > 0
+}
+
+type SignupCount struct {
+	// YYYY-MM, UTC
+	Month	string	`json:"month"`
+	Count	int		`json:"count"`
+}
+
+type AccountsReport struct {
+	ReportID			int64			`json:"reportID"`
+	Total				int				`json:"total"`
+	Active				int				`json:"active"`
+	Inactive			int				`json:"inactive"`
+	SignupsLast30Days	int				`json:"signupsLast30Days"`
+	// oldest month first
+	Signups				[]SignupCount	`json:"signups"`
+	GeneratedAt			*time.Time		`json:"generatedAt,omitempty"`
+	// nil for reports stored before accounts were validated
+	DataQuality			*report.DataQuality	`json:"dataQuality,omitempty"`
+}
+
+type AccountsReportDatabase = report.Database[AccountsReport]
+
+// Kind generates accounts reports from the accounts service, cross
+// referenced with the habits and tasks services
+var Kind = report.Kind[AccountActivity, AccountsReport]{
+	Name:		"accounts",
+	Fetch:			StreamAccountActivity,
+	NewAggregator:	func() report.Aggregator[AccountActivity, AccountsReport] { return NewAggregator() },
+	Schema:			schema,
+}
+
+// where the accounts service is and how it pages /accounts; set from the
+// configuration at startup
+var (
+	URL = ACCOUNTS_URL
+	Paging upstream.Paging
+)
+
+// PingUpstream checks that the accounts service answers HTTP requests at
+// all
+func PingUpstream(ctx context.Context) error {
+	return upstream.Ping(ctx, URL)
+}
+
+// StreamAccounts passes every account known to the accounts service to
+// yield as it is decoded
+func StreamAccounts(ctx context.Context, yield func(Account) error) (err error) {
+	logger := logging.FromContext(ctx).With("upstream", "accounts")
+	start := time.Now()
+	defer func() {
+		metrics.ObserveUpstreamFetch("accounts", start, err)
+	}()
+
+	count, err := upstream.Stream(ctx, URL + "/accounts", Paging, yield)
+	if err != nil {
+		logger.Warn("upstream fetch failed", "count", count, "error", err)
+		return fmt.Errorf("Accounts unavailable: %v", err)
+	}
+
+	logger.Debug("upstream fetch complete", "count", count,
+		"duration", time.Since(start))
+	return nil
+}
+
+// StreamAccountActivity counts each user's habits and tasks, streaming both
+// services concurrently, then streams the accounts with their counts. Only
+// the per-user counts are held in memory.
+func StreamAccountActivity(ctx context.Context, yield func(AccountActivity) error) error {
+	var (
+		wg			sync.WaitGroup
+		habitsErr	error
+		tasksErr	error
+	)
+	habitsByUser := make(map[string]int)
+	tasksByUser := make(map[string]int)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		habitsErr = habits.StreamHabits(ctx, func(habit habits.Habit) error {
+			if len(habit.Validate()) == 0 {
+				habitsByUser[habit.UserID]++
+			}
+			return nil
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		tasksErr = tasks.StreamTasks(ctx, func(task tasks.Task) error {
+			if len(task.Validate()) == 0 {
+				tasksByUser[task.UserID]++
+			}
+			return nil
+		})
+	}()
+	wg.Wait()
+	if habitsErr != nil {
+		return habitsErr
+	}
+	if tasksErr != nil {
+		return tasksErr
+	}
+
+	return StreamAccounts(ctx, func(account Account) error {
+		return yield(AccountActivity{
+			Account:	account,
+			Habits:		habitsByUser[account.UserID],
+			Tasks:		tasksByUser[account.UserID],
+		})
+	})
+}
+
+// Aggregator builds an accounts report one account at a time. Sign-ups are
+// bucketed relative to the time it was created.
+type Aggregator struct {
+	now				time.Time
+	firstMonth		time.Time
+	recentSince		int64
+	total			int
+	active			int
+	recent			int
+	signupsByMonth	map[string]int
+	quality			report.DataQuality
+}
+
+func NewAggregator() *Aggregator {
+	now := time.Now().UTC()
+	return &Aggregator{
+		now:			now,
+		firstMonth:		time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).
+			AddDate(0, -(SIGNUP_MONTHS - 1), 0),
+		recentSince:	now.AddDate(0, 0, -SIGNUP_RECENT_DAYS).Unix(),
+		signupsByMonth:	make(map[string]int),
+		quality:		report.NewDataQuality(),
+	}
+}
+
+// Add counts a valid account; invalid ones are only recorded in the data
+// quality section
+func (a *Aggregator) Add(account AccountActivity) {
+	if !a.quality.Record(account.UserID, account.Validate()) {
+		return
+	}
+	a.total++
+	if account.Active() {
+		a.active++
+	}
+	if account.CreatedAt >= a.recentSince {
+		a.recent++
+	}
+	created := time.Unix(account.CreatedAt, 0).UTC()
+	if !created.Before(a.firstMonth) {
+		a.signupsByMonth[created.Format("2006-01")]++
+	}
+}
+
+func (a *Aggregator) Report() AccountsReport {
+	var accountsReport AccountsReport
+	generatedAt := a.now
+	accountsReport.GeneratedAt = &generatedAt
+	accountsReport.Total = a.total
+	accountsReport.Active = a.active
+	accountsReport.Inactive = a.total - a.active
+	accountsReport.SignupsLast30Days = a.recent
+	quality := a.quality
+	accountsReport.DataQuality = &quality
+	accountsReport.Signups = make([]SignupCount, 0, SIGNUP_MONTHS)
+	for month := a.firstMonth; !month.After(a.now); month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01")
+		accountsReport.Signups = append(accountsReport.Signups,
+			SignupCount{key, a.signupsByMonth[key]})
+	}
+
+	recordLatestAccountsReport(accountsReport)
+	return accountsReport
+}
+
+func GenerateAccountsReport(ctx context.Context) (AccountsReport, error) {
+	return Kind.Generate(ctx)
+}
+
+// expose the counts of the latest report as gauges
+func recordLatestAccountsReport(report AccountsReport) {
+	metrics.AccountsLatest.WithLabelValues("total").Set(float64(report.Total))
+	metrics.AccountsLatest.WithLabelValues("active").Set(float64(report.Active))
+	metrics.AccountsLatest.WithLabelValues("inactive").Set(float64(report.Inactive))
+	metrics.AccountsLatest.WithLabelValues("signups_last_30_days").Set(float64(report.SignupsLast30Days))
+}
@@ -0,0 +1,24 @@
+package accounts
+
+// reasons an account is left out of a report's metrics
+const (
+	REJECT_MISSING_USER_ID = "missing_user_id"
+	REJECT_MISSING_CREATED_AT = "missing_created_at"
+	REJECT_NEGATIVE_CREATED_AT = "negative_created_at"
+)
+
+// Validate returns every reason the account cannot be trusted, none if it
+// is valid. An account without its own id cannot be matched with habits or
+// tasks, and one without a creation date cannot be bucketed by sign-up.
+func (a Account) Validate() []string {
+	var reasons []string
+	if a.UserID == "" {
+		reasons = append(reasons, REJECT_MISSING_USER_ID)
+	}
+	if a.CreatedAt == 0 {
+		reasons = append(reasons, REJECT_MISSING_CREATED_AT)
+	} else if a.CreatedAt < 0 {
+		reasons = append(reasons, REJECT_NEGATIVE_CREATED_AT)
+	}
+	return reasons
+}
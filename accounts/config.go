@@ -0,0 +1,4 @@
+package accounts
+
+// DB holds the accounts reports; opened at startup by whoever serves them
+var DB AccountsReportDatabase
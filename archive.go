@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"github/godspeedkil/admin-report/report"
+	"log/slog"
+)
+
+// values of ARCHIVE_INPUTS
+const (
+	ARCHIVE_NONE = "none"
+	ARCHIVE_DIR = "dir"
+	ARCHIVE_MYSQL = "mysql"
+)
+
+// inputArchive keeps the upstream payloads each habits, tasks or accounts
+// report was generated from; nil when archiving is off
+var inputArchive report.Archive
+
+func openArchive(cfg config, logger *slog.Logger) error {
+	var err error
+	switch cfg.Archive.Kind {
+	case ARCHIVE_DIR:
+		inputArchive, err = report.NewDirArchive(cfg.Archive.Dir)
+	case ARCHIVE_MYSQL:
+		inputArchive, err = report.NewMySQLArchive(report.DefaultMySQLConfig, logger)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("input archive: %v", err)
+	}
+	logger.Info("report inputs archived", "archive", cfg.Archive.Kind)
+	return nil
+}
+
+// closeArchive reports whether the archive, if any, closed cleanly
+func closeArchive(logger *slog.Logger) bool {
+	if inputArchive == nil {
+		return true
+	}
+	if err := inputArchive.Close(); err != nil {
+		logger.Error("could not close input archive", "error", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionRead Action = "read"
+	ActionList Action = "list"
+	ActionDelete Action = "delete"
+	ActionExport Action = "export"
+	ActionRegenerate Action = "regenerate"
+)
+
+const (
+	OutcomeSuccess = "success"
+	// rejected by authentication or authorization
+	OutcomeDenied = "denied"
+	// any other 4xx
+	OutcomeFailure = "failure"
+	OutcomeError = "error"
+	// actor of requests that never authenticated
+	ANONYMOUS = "anonymous"
+)
+
+// Entry is one admin API call
+type Entry struct {
+	EntryID		int64		`json:"entryID"`
+	Timestamp	time.Time	`json:"timestamp"`
+	Actor		string		`json:"actor"`
+	Action		Action		`json:"action"`
+	ReportType	string		`json:"reportType"`
+	ReportID	*int64		`json:"reportID"`
+	ClientIP	string		`json:"clientIP"`
+	Outcome		string		`json:"outcome"`
+	Status		int			`json:"status"`
+	RequestID	string		`json:"requestID"`
+}
+
+// Filter narrows ListEntries; zero fields match everything
+type Filter struct {
+	Actor	string
+	Action	Action
+	Since	time.Time
+	Until	time.Time
+	Limit	int
+}
+
+type AuditDatabase interface {
+	AddEntry(ctx context.Context, entry *Entry) (entryId int64, err error)
+
+	// newest first
+	ListEntries(ctx context.Context, filter Filter) ([]Entry, error)
+
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// OutcomeForStatus classifies a response status code
+func OutcomeForStatus(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return OutcomeDenied
+	case status >= 500:
+		return OutcomeError
+	case status >= 400:
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+type contextKey int
+
+const entryKey contextKey = iota
+
+// WithEntry stores the entry being built for the current request, so that
+// code further down the chain can fill in what only it knows
+func WithEntry(ctx context.Context, entry *Entry) context.Context {
+	return context.WithValue(ctx, entryKey, entry)
+}
+
+func SetActor(ctx context.Context, actor string) {
+	if entry, ok := ctx.Value(entryKey).(*Entry); ok {
+		entry.Actor = actor
+	}
+}
+
+func SetReportID(ctx context.Context, reportId int64) {
+	if entry, ok := ctx.Value(entryKey).(*Entry); ok {
+		entry.ReportID = &reportId
+	}
+}
+
+// SetAction overrides the entry's action, e.g. a read served as a download
+// is an export
+func SetAction(ctx context.Context, action Action) {
+	if entry, ok := ctx.Value(entryKey).(*Entry); ok {
+		entry.Action = action
+	}
+}
@@ -0,0 +1,202 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/report"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const insertStatement = `
+		INSERT INTO audit_log(
+			occurred_at, actor, action, report_type, report_id,
+				client_ip, outcome, status, request_id
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`
+const listStatement = `
+		SELECT entry_id, occurred_at, actor, action, report_type, report_id,
+			client_ip, outcome, status, request_id
+		FROM audit_log
+	`
+const defaultListLimit = 100
+const maxListLimit = 1000
+// characters of actor VARCHAR(255); JWT subjects have no length limit
+const maxActorLength = 255
+var createTableStatements = []string{
+	fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s DEFAULT CHARACTER SET = 'utf8' DEFAULT COLLATE 'utf8_general_ci';", report.DATABASE_NAME),
+	fmt.Sprintf("USE %s;", report.DATABASE_NAME),
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		entry_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+		occurred_at DATETIME(3) NOT NULL,
+		actor VARCHAR(255) NOT NULL,
+		action VARCHAR(32) NOT NULL,
+		report_type VARCHAR(32) NOT NULL,
+		report_id INT UNSIGNED,
+		client_ip VARCHAR(64) NOT NULL,
+		outcome VARCHAR(16) NOT NULL,
+		status SMALLINT UNSIGNED NOT NULL,
+		request_id VARCHAR(64) NOT NULL,
+		INDEX audit_log_occurred_at (occurred_at),
+		INDEX audit_log_actor (actor, occurred_at),
+		INDEX audit_log_action (action, occurred_at)
+	);`,
+}
+
+type mysqlDB struct {
+	conn *sql.DB
+
+	insert 		*sql.Stmt
+}
+
+var _ AuditDatabase = &mysqlDB{}
+
+func newMySQLDB(config report.MySQLConfig, logger *slog.Logger) (AuditDatabase, error) {
+	logger = logger.With("component", "audit_db")
+	if err := config.EnsureTableExists("audit_log", createTableStatements, nil, logger); err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("mysql", config.DataStoreName(report.DATABASE_NAME))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+
+	db := &mysqlDB {
+		conn: conn,
+	}
+
+	if db.insert, err = conn.Prepare(insertStatement); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: prepare insert: %v", err)
+	}
+
+	logger.Info("connected to mysql", "host", config.Host, "port", config.Port)
+	return db, nil
+}
+
+// Close releases the prepared statements and the connection pool
+func (db *mysqlDB) Close() error {
+	var errs []error
+	if db.insert != nil {
+		errs = append(errs, db.insert.Close())
+	}
+	errs = append(errs, db.conn.Close())
+	return errors.Join(errs...)
+}
+
+// Ping verifies a connection to the database can be established
+func (db *mysqlDB) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+func scanEntry(s report.RowScanner) (*Entry, error) {
+	var (
+		entry		Entry
+		reportId	sql.NullInt64
+	)
+	if err := s.Scan(&entry.EntryID, &entry.Timestamp, &entry.Actor,
+		&entry.Action, &entry.ReportType, &reportId, &entry.ClientIP,
+		&entry.Outcome, &entry.Status, &entry.RequestID); err != nil {
+		return nil, err
+	}
+	if reportId.Valid {
+		entry.ReportID = &reportId.Int64
+	}
+	return &entry, nil
+}
+
+func (db *mysqlDB) AddEntry(ctx context.Context, entry *Entry) (entryId int64, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery("audit_log", "add", start, err)
+	}()
+
+	result, err := db.insert.ExecContext(ctx, entry.Timestamp.UTC(), truncateActor(entry.Actor),
+		string(entry.Action), entry.ReportType, entry.ReportID, entry.ClientIP,
+		entry.Outcome, entry.Status, entry.RequestID)
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not add audit entry: %v", err)
+	}
+
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not get last insert ID: %v", err)
+	}
+	entry.EntryID = lastInsertID
+	return lastInsertID, nil
+}
+
+// truncateActor cuts actor to what the column holds, so that a long subject
+// does not fail the insert under strict mode and lose the entry
+func truncateActor(actor string) string {
+	runes := []rune(actor)
+	if len(runes) <= maxActorLength {
+		return actor
+	}
+	return string(runes[:maxActorLength])
+}
+
+func (db *mysqlDB) ListEntries(ctx context.Context, filter Filter) (entries []Entry, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery("audit_log", "list", start, err)
+	}()
+
+	var conditions []string
+	var args []interface{}
+	if filter.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, string(filter.Action))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "occurred_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "occurred_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	query := listStatement
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY occurred_at DESC, entry_id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not list audit entries: %v", err)
+	}
+	defer rows.Close()
+
+	entries = make([]Entry, 0)
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read audit entry: %v", err)
+		}
+		entries = append(entries, *entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("mysql: could not list audit entries: %v", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,16 @@
+package audit
+
+import (
+	"github/godspeedkil/admin-report/report"
+	"log/slog"
+)
+
+var DB AuditDatabase
+
+// Init connects DB to the audit log database, creating the table if
+// needed. logger receives the database layer's startup messages.
+func Init(logger *slog.Logger) error {
+	var err error
+	DB, err = newMySQLDB(report.DefaultMySQLConfig, logger)
+	return err
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github/godspeedkil/admin-report/audit"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// listAuditHandler returns audit entries, newest first, optionally filtered
+// by ?actor=, ?action=, ?since= and ?until= (RFC 3339) and capped by ?limit=
+func listAuditHandler(w http.ResponseWriter, r *http.Request) *appError {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: audit.Action(query.Get("action")),
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return badRequestf(err, "since must be an RFC 3339 timestamp")
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return badRequestf(err, "until must be an RFC 3339 timestamp")
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 1 {
+			return badRequestf(fmt.Errorf("invalid limit %q", limit), "limit must be a positive integer")
+		}
+	}
+
+	entries, err := audit.DB.ListEntries(r.Context(), filter)
+	if err != nil {
+		return appErrorf(err, "could not list audit entries")
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(entries)
+	return nil
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	API_KEY_HEADER = "X-API-Key"
+	METHOD_API_KEY = "api_key"
+	METHOD_JWT = "jwt"
+)
+
+var (
+	ErrNoCredentials = errors.New("auth: no credentials")
+	ErrInvalidAPIKey = errors.New("auth: invalid api key")
+	ErrInvalidToken = errors.New("auth: invalid bearer token")
+)
+
+// Config lists the credentials accepted by an Authenticator
+type Config struct {
+	// principal name -> static API key
+	APIKeys		map[string]string
+	// principal name -> roles granted to its API key
+	APIKeyRoles	map[string][]Role
+	// key id -> HMAC secret; tokens without a kid header are tried
+	// against every secret
+	JWTKeys		map[string][]byte
+	// when set, tokens must carry a matching iss / aud claim
+	JWTIssuer	string
+	JWTAudience	string
+	// claim holding a token's roles, "roles" when empty
+	JWTRolesClaim	string
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name	string
+	Method	string
+	Roles	[]Role
+}
+
+type apiKey struct {
+	principal	string
+	hash		[sha256.Size]byte
+	roles		[]Role
+}
+
+type Authenticator struct {
+	apiKeys		[]apiKey
+	jwtKeys		map[string][]byte
+	rolesClaim	string
+	parser		*jwt.Parser
+}
+
+func New(config Config) (*Authenticator, error) {
+	a := &Authenticator{
+		jwtKeys:    make(map[string][]byte, len(config.JWTKeys)),
+		rolesClaim: config.JWTRolesClaim,
+	}
+	if a.rolesClaim == "" {
+		a.rolesClaim = "roles"
+	}
+	for principal, key := range config.APIKeys {
+		if principal == "" || key == "" {
+			return nil, fmt.Errorf("auth: api key entries need a principal and a key")
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{principal,
+			sha256.Sum256([]byte(key)), config.APIKeyRoles[principal]})
+	}
+	for principal := range config.APIKeyRoles {
+		if _, ok := config.APIKeys[principal]; !ok {
+			return nil, fmt.Errorf("auth: roles configured for %q, which has no api key", principal)
+		}
+	}
+	for kid, secret := range config.JWTKeys {
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("auth: empty jwt secret for key %q", kid)
+		}
+		a.jwtKeys[kid] = secret
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(config.JWTIssuer))
+	}
+	if config.JWTAudience != "" {
+		options = append(options, jwt.WithAudience(config.JWTAudience))
+	}
+	a.parser = jwt.NewParser(options...)
+	return a, nil
+}
+
+// Enabled reports whether any credential is configured at all; without
+// one every request is rejected
+func (a *Authenticator) Enabled() bool {
+	return len(a.apiKeys) > 0 || len(a.jwtKeys) > 0
+}
+
+// Authenticate identifies the caller of r from its X-API-Key header or its
+// Authorization: Bearer token
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(API_KEY_HEADER); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return a.authenticateToken(strings.TrimSpace(token))
+	}
+	return nil, ErrNoCredentials
+}
+
+// compares against every configured key so timing doesn't reveal which
+// principal, if any, matched
+func (a *Authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	hash := sha256.Sum256([]byte(key))
+	var match *apiKey
+	for i := range a.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], a.apiKeys[i].hash[:]) == 1 {
+			match = &a.apiKeys[i]
+		}
+	}
+	if match == nil {
+		return nil, ErrInvalidAPIKey
+	}
+	return &Principal{Name: match.principal, Method: METHOD_API_KEY,
+		Roles: match.roles}, nil
+}
+
+func (a *Authenticator) authenticateToken(token string) (*Principal, error) {
+	if len(a.jwtKeys) == 0 {
+		return nil, ErrInvalidToken
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.keyFunc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+	return &Principal{Name: subject, Method: METHOD_JWT,
+		Roles: rolesFromClaim(claims[a.rolesClaim])}, nil
+}
+
+func (a *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	if kid, ok := token.Header["kid"].(string); ok {
+		secret, found := a.jwtKeys[kid]
+		if !found {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return secret, nil
+	}
+	set := jwt.VerificationKeySet{}
+	for _, secret := range a.jwtKeys {
+		set.Keys = append(set.Keys, secret)
+	}
+	return set, nil
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFromContext returns the caller stored by WithPrincipal, or nil
+// for unauthenticated requests
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey).(*Principal)
+	return p
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testKid = "k1"
+	testIssuer = "https://issuer.example"
+	testAudience = "admin-report"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	a, err := New(Config{
+		APIKeys:		map[string]string{"cron": "s3cret"},
+		APIKeyRoles:	map[string][]Role{"cron": {RoleOperator}},
+		JWTKeys:		map[string][]byte{testKid: testSecret},
+		JWTIssuer:		testIssuer,
+		JWTAudience:	testAudience,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return a
+}
+
+// claims accepted by newTestAuthenticator, to be altered per case
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":	"alice",
+		"iss":	testIssuer,
+		"aud":	testAudience,
+		"exp":	time.Now().Add(time.Hour).Unix(),
+		"roles":	[]string{"viewer", "admin"},
+	}
+}
+
+func signHS256(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(testSecret)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return signed
+}
+
+func authenticateBearer(a *Authenticator, token string) (*Principal, error) {
+	r := httptest.NewRequest("GET", "/admin/habits/reports", nil)
+	r.Header.Set("Authorization", "Bearer " + token)
+	return a.Authenticate(r)
+}
+
+func TestAuthenticateToken(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa key: %v", err)
+	}
+	rs256, err := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims()).SignedString(rsaKey)
+	if err != nil {
+		t.Fatalf("sign RS256: %v", err)
+	}
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("sign none: %v", err)
+	}
+
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name	string
+		token	string
+		wantErr	bool
+	}{
+		{"valid", signHS256(t, testKid, validClaims()), false},
+		{"valid without kid", signHS256(t, "", validClaims()), false},
+		{"expired", signHS256(t, testKid, with("exp", time.Now().Add(-time.Hour).Unix())), true},
+		{"no exp", signHS256(t, testKid, with("exp", nil)), true},
+		{"alg none", none, true},
+		{"alg RS256", rs256, true},
+		{"unknown kid", signHS256(t, "k2", validClaims()), true},
+		{"missing sub", signHS256(t, testKid, with("sub", nil)), true},
+		{"empty sub", signHS256(t, testKid, with("sub", "")), true},
+		{"wrong issuer", signHS256(t, testKid, with("iss", "https://other.example")), true},
+		{"wrong audience", signHS256(t, testKid, with("aud", "other")), true},
+		{"garbage", "not.a.token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := authenticateBearer(a, tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("err = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if principal.Name != "alice" || principal.Method != METHOD_JWT {
+				t.Errorf("principal = %+v", principal)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	r := httptest.NewRequest("GET", "/admin/habits/reports", nil)
+	r.Header.Set(API_KEY_HEADER, "s3cret")
+	principal, err := a.Authenticate(r)
+	if err != nil {
+		t.Fatalf("valid key: %v", err)
+	}
+	if principal.Name != "cron" || principal.Method != METHOD_API_KEY ||
+		!slices.Equal(principal.Roles, []Role{RoleOperator}) {
+		t.Errorf("principal = %+v", principal)
+	}
+
+	r.Header.Set(API_KEY_HEADER, "wrong")
+	if _, err := a.Authenticate(r); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("wrong key: err = %v, want ErrInvalidAPIKey", err)
+	}
+
+	if _, err := a.Authenticate(httptest.NewRequest("GET", "/", nil)); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no credentials: err = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestTokenRoles(t *testing.T) {
+	a := newTestAuthenticator(t)
+
+	tests := []struct {
+		name	string
+		claim	interface{}
+		want	[]Role
+	}{
+		{"array", []string{"viewer", "admin"}, []Role{RoleViewer, RoleAdmin}},
+		{"space separated", "operator viewer", []Role{RoleOperator, RoleViewer}},
+		{"unknown roles dropped", []interface{}{"root", "viewer", 3}, []Role{RoleViewer}},
+		{"absent", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			claims["roles"] = tt.claim
+			if tt.claim == nil {
+				delete(claims, "roles")
+			}
+			principal, err := authenticateBearer(a, signHS256(t, testKid, claims))
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if !slices.Equal(principal.Roles, tt.want) {
+				t.Errorf("roles = %v, want %v", principal.Roles, tt.want)
+			}
+		})
+	}
+}
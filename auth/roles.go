@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role grants access to report operations. Roles are ordered: each one
+// includes every permission of the roles below it.
+type Role string
+
+const (
+	// read and list reports
+	RoleViewer Role = "viewer"
+	// also create reports
+	RoleOperator Role = "operator"
+	// also delete and regenerate reports
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("auth: unknown role %q", s)
+	}
+	return role, nil
+}
+
+// HasRole reports whether p holds required or a role above it
+func (p *Principal) HasRole(required Role) bool {
+	for _, role := range p.Roles {
+		if roleRank[role] >= roleRank[required] {
+			return true
+		}
+	}
+	return false
+}
+
+// parse the roles claim of a token, either a list or a space separated
+// string; unknown role names are dropped
+func rolesFromClaim(claim interface{}) []Role {
+	var names []string
+	switch value := claim.(type) {
+	case string:
+		names = strings.Fields(value)
+	case []interface{}:
+		for _, v := range value {
+			if name, ok := v.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var roles []Role
+	for _, name := range names {
+		if role, err := ParseRole(name); err == nil {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
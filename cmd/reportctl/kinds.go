@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github/godspeedkil/admin-report/accounts"
+	"github/godspeedkil/admin-report/registry"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/sources"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// cliKind runs the commands against one kind of registry.All, keeping
+// track of what it opened so that close can release it
+type cliKind struct {
+	registry.Kind
+	openedDBs		[]registry.Kind
+	openedSources	[]string
+}
+
+// kindNames lists the kinds for usage and error messages
+func kindNames() string {
+	names := make([]string, len(registry.All))
+	for i, kind := range registry.All {
+		names[i] = kind.Name()
+	}
+	return strings.Join(names, "|")
+}
+
+// checkFormat also rejects CSV for kinds without a CSV form
+func (k *cliKind) checkFormat(format string, allowed ...string) error {
+	if !slices.Contains(allowed, format) {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if format == FORMAT_CSV && k.CSVColumns() == nil {
+		return fmt.Errorf("%s reports have no CSV form, use -format json", k.Name())
+	}
+	return nil
+}
+
+// openSources reads records from input if set, else from url if set, else
+// from the sources configured by the <SOURCE>_* settings; input and url
+// only apply to kinds reading a single source
+func (k *cliKind) openSources(ctx context.Context, input, url string) error {
+	names := k.RecordSources()
+	if (input != "" || url != "") && len(names) != 1 {
+		return fmt.Errorf("-input and -url need a kind reading a single source, %s reads %s",
+			k.Name(), strings.Join(names, " and "))
+	}
+	for _, name := range names {
+		config, err := sources.Load(name)
+		if err != nil {
+			return err
+		}
+		if err := sources.Open(ctx, name, config.Override(input, url)); err != nil {
+			return err
+		}
+		k.openedSources = append(k.openedSources, name)
+	}
+	var err error
+	accounts.URL, accounts.Paging, err = sources.LoadAccounts()
+	return err
+}
+
+// openDBs opens the kind's database, if withOwn, after those of the kinds
+// its generation stores reports of, if withStored
+func (k *cliKind) openDBs(logger *slog.Logger, withStored, withOwn bool) error {
+	var names []string
+	if withStored {
+		names = append(names, k.StoredKinds()...)
+	}
+	if withOwn {
+		names = append(names, k.Name())
+	}
+	for _, name := range names {
+		kind := registry.Lookup(name)
+		if err := kind.OpenDB(logger); err != nil {
+			return err
+		}
+		k.openedDBs = append(k.openedDBs, kind)
+	}
+	return nil
+}
+
+// close releases the databases and sources opened, last opened first
+func (k *cliKind) close() error {
+	var errs []error
+	for _, kind := range slices.Backward(k.openedDBs) {
+		errs = append(errs, kind.CloseDB())
+	}
+	for _, name := range slices.Backward(k.openedSources) {
+		errs = append(errs, sources.Close(name))
+	}
+	k.openedDBs, k.openedSources = nil, nil
+	return errors.Join(errs...)
+}
+
+func (k *cliKind) generate(ctx context.Context, w io.Writer, format string, save bool) error {
+	rep, err := k.GenerateReport(ctx)
+	if err != nil {
+		return err
+	}
+	if save {
+		reportId, err := k.SaveReport(ctx, rep)
+		if err != nil {
+			return err
+		}
+		// the stored report carries its id, the generated one does not
+		if rep, err = k.GetReport(ctx, reportId); err != nil {
+			return err
+		}
+	}
+	return k.write(w, format, []any{rep}, true)
+}
+
+func (k *cliKind) list(ctx context.Context, w io.Writer, format string, limit, offset int) error {
+	reports, err := k.ListReports(ctx, limit, offset)
+	if err != nil {
+		return err
+	}
+	return k.write(w, format, reports, false)
+}
+
+func (k *cliKind) show(ctx context.Context, w io.Writer, format string, reportId int64) error {
+	rep, err := k.GetReport(ctx, reportId)
+	if err == report.ErrReportNotFound {
+		return fmt.Errorf("%s report %d not found", k.Name(), reportId)
+	}
+	if err != nil {
+		return err
+	}
+	return k.write(w, format, []any{rep}, true)
+}
+
+// export writes every stored report, newest first, a page at a time so
+// that CSV output never holds more than a page
+func (k *cliKind) export(ctx context.Context, w io.Writer, format string, limit int) error {
+	var all []any
+	var csvWriter *csv.Writer
+	if format == FORMAT_CSV {
+		csvWriter = csv.NewWriter(w)
+		csvWriter.Write(k.CSVColumns())
+	}
+	for offset := 0; limit == 0 || offset < limit; offset += EXPORT_PAGE_SIZE {
+		pageSize := EXPORT_PAGE_SIZE
+		if limit > 0 && limit - offset < pageSize {
+			pageSize = limit - offset
+		}
+		reports, err := k.ListReports(ctx, pageSize, offset)
+		if err != nil {
+			return err
+		}
+		if csvWriter != nil {
+			for _, rep := range reports {
+				csvWriter.Write(report.CSVEscapeRecord(k.CSVRow(rep)))
+			}
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return err
+			}
+		} else {
+			all = append(all, reports...)
+		}
+		if len(reports) < pageSize {
+			break
+		}
+	}
+	if csvWriter != nil {
+		return nil
+	}
+	if all == nil {
+		all = make([]any, 0)
+	}
+	return writeJSON(w, all)
+}
+
+// write prints reports in format; a single report is printed as a JSON
+// object and as a vertical table. Kinds without a CSV form print their
+// tables as JSON.
+func (k *cliKind) write(w io.Writer, format string, reports []any, single bool) error {
+	if format == FORMAT_TABLE && k.CSVColumns() == nil {
+		format = FORMAT_JSON
+	}
+	switch format {
+	case FORMAT_JSON:
+		if single {
+			return writeJSON(w, reports[0])
+		}
+		return writeJSON(w, reports)
+	case FORMAT_CSV:
+		writer := csv.NewWriter(w)
+		writer.Write(k.CSVColumns())
+		for _, rep := range reports {
+			writer.Write(report.CSVEscapeRecord(k.CSVRow(rep)))
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if single {
+		record := k.CSVRow(reports[0])
+		for i, column := range k.CSVColumns() {
+			fmt.Fprintf(table, "%s\t%s\n", column, record[i])
+		}
+	} else {
+		fmt.Fprintln(table, strings.Join(k.CSVColumns(), "\t"))
+		for _, rep := range reports {
+			fmt.Fprintln(table, strings.Join(k.CSVRow(rep), "\t"))
+		}
+	}
+	return table.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,236 @@
+// reportctl generates, lists and exports reports of every kind the HTTP
+// server serves from the command line, using the same registry and
+// packages.
+//
+//	reportctl report <kind> [-input file] [-url url] [-format f] [-save]
+//	reportctl report list <kind> [-limit n] [-offset n] [-format f]
+//	reportctl report show <kind> <id> [-format f]
+//	reportctl export <kind> [-limit n] [-format csv|json] [-o file]
+//
+// Kinds are habits, tasks, accounts and summary. Formats are table, json
+// and csv; kinds without a CSV form print tables as JSON. Records are read from the source the
+// server is configured with (HABITS_SOURCE, HABITS_URL, HABITS_PAGINATION,
+// HABITS_SQL_DSN and so on, ACCOUNTS_URL and ACCOUNTS_PAGINATION), unless
+// -input or -url replace it for kinds reading a single source. Storage is
+// only opened by -save, list, show and export, and by generating a summary,
+// which stores a habits and a tasks report.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/registry"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+const (
+	FORMAT_TABLE = "table"
+	FORMAT_JSON = "json"
+	FORMAT_CSV = "csv"
+	DEFAULT_LIST_LIMIT = 20
+	// export pages through stored reports this many at a time
+	EXPORT_PAGE_SIZE = 500
+)
+
+const usage = `usage:
+  reportctl report <kind> [-input file] [-url url] [-format table|json|csv] [-save]
+  reportctl report list <kind> [-limit n] [-offset n] [-format table|json|csv]
+  reportctl report show <kind> <id> [-format table|json|csv]
+  reportctl export <kind> [-limit n] [-format csv|json] [-o file]
+kinds: %s
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	// logs go to stderr so that stdout only carries the output
+	logger, err := logging.New(os.Stderr, envString("LOG_LEVEL", "warn"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx = logging.WithLogger(ctx, logger)
+
+	err = run(ctx, logger, os.Args[1:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, usage, kindNames())
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reportctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, logger *slog.Logger, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	switch args[0] {
+	case "report":
+		switch args[1] {
+		case "list":
+			return listCommand(ctx, logger, args[2:])
+		case "show":
+			return showCommand(ctx, logger, args[2:])
+		}
+		return generateCommand(ctx, logger, args[1:])
+	case "export":
+		return exportCommand(ctx, logger, args[1:])
+	}
+	return errUsage
+}
+
+// lookupKind takes the report kind off the front of args
+func lookupKind(args []string) (*cliKind, []string, error) {
+	if len(args) == 0 {
+		return nil, nil, errUsage
+	}
+	kind := registry.Lookup(args[0])
+	if kind == nil {
+		return nil, nil, fmt.Errorf("unknown report kind %q, want %s", args[0], kindNames())
+	}
+	return &cliKind{Kind: kind}, args[1:], nil
+}
+
+func generateCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	kind, args, err := lookupKind(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	input := flags.String("input", "", "read records from a JSON array or NDJSON `file`")
+	url := flags.String("url", "", "read records from the service at `url`")
+	format := flags.String("format", FORMAT_TABLE, "output format: table, json or csv")
+	save := flags.Bool("save", false, "store the report")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	if err := kind.checkFormat(*format, FORMAT_TABLE, FORMAT_JSON, FORMAT_CSV); err != nil {
+		return err
+	}
+	if *input != "" && *url != "" {
+		return errors.New("-input and -url are mutually exclusive")
+	}
+
+	defer kind.close()
+	if err := kind.openSources(ctx, *input, *url); err != nil {
+		return err
+	}
+	if err := kind.openDBs(logger, true, *save); err != nil {
+		return err
+	}
+	return kind.generate(ctx, os.Stdout, *format, *save)
+}
+
+func listCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	kind, args, err := lookupKind(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	limit := flags.Int("limit", DEFAULT_LIST_LIMIT, "list at most `n` reports, newest first")
+	offset := flags.Int("offset", 0, "skip the newest `n` reports")
+	format := flags.String("format", FORMAT_TABLE, "output format: table, json or csv")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	if err := kind.checkFormat(*format, FORMAT_TABLE, FORMAT_JSON, FORMAT_CSV); err != nil {
+		return err
+	}
+	if *limit < 1 || *offset < 0 {
+		return errors.New("-limit must be positive and -offset not negative")
+	}
+
+	defer kind.close()
+	if err := kind.openDBs(logger, false, true); err != nil {
+		return err
+	}
+	return kind.list(ctx, os.Stdout, *format, *limit, *offset)
+}
+
+func showCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	kind, args, err := lookupKind(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	format := flags.String("format", FORMAT_TABLE, "output format: table, json or csv")
+	// the id comes before the flags
+	if len(args) == 0 {
+		return errUsage
+	}
+	reportId, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("report id must be an integer, got %q", args[0])
+	}
+	if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	if err := kind.checkFormat(*format, FORMAT_TABLE, FORMAT_JSON, FORMAT_CSV); err != nil {
+		return err
+	}
+
+	defer kind.close()
+	if err := kind.openDBs(logger, false, true); err != nil {
+		return err
+	}
+	return kind.show(ctx, os.Stdout, *format, reportId)
+}
+
+func exportCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	kind, args, err := lookupKind(args)
+	if err != nil {
+		return err
+	}
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	limit := flags.Int("limit", 0, "export at most `n` reports, newest first; 0 exports all")
+	format := flags.String("format", FORMAT_CSV, "output format: csv or json")
+	output := flags.String("o", "", "write to `file` instead of stdout")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	if err := kind.checkFormat(*format, FORMAT_JSON, FORMAT_CSV); err != nil {
+		return err
+	}
+	if *limit < 0 {
+		return errors.New("-limit must not be negative")
+	}
+
+	defer kind.close()
+	if err := kind.openDBs(logger, false, true); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			return err
+		}
+	}
+	err = kind.export(ctx, out, *format, *limit)
+	if *output != "" {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"github/godspeedkil/admin-report/auth"
+	"github/godspeedkil/admin-report/ratelimit"
+	"github/godspeedkil/admin-report/registry"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/sources"
+	"github/godspeedkil/admin-report/upstream"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the server settings read from the environment
+type config struct {
+	LogLevel			string
+	ListenAddr			string
+	ReadTimeout			time.Duration
+	// report creation waits on a full upstream fetch, keep this generous
+	WriteTimeout		time.Duration
+	IdleTimeout			time.Duration
+	// how long in-flight requests and background jobs get to finish
+	ShutdownTimeout		time.Duration
+	// also probe the habits and tasks services from /readyz
+	ReadyCheckUpstreams	bool
+	// take client addresses from the last X-Forwarded-For entry, appended by
+	// a single trusted proxy
+	TrustProxyHeaders	bool
+	Auth				auth.Config
+	CORS				corsConfig
+	// route name -> limits
+	RateLimits			map[string]routeLimits
+	// principal -> per caller limit replacing the route's default
+	RateLimitKeys		map[string]ratelimit.Limit
+	// "habits" or "tasks" -> where those records are read from
+	Sources				map[string]sources.Config
+	// base URL of the accounts service
+	AccountsURL			string
+	// how the accounts listing endpoint is paged
+	AccountsPaging		upstream.Paging
+	// how often the retention pruner runs; 0 disables it
+	RetentionInterval	time.Duration
+	// log and count what the pruner would remove without removing it
+	RetentionDryRun		bool
+	// report kind -> retention policy
+	Retention			map[string]report.Retention
+	// how often the rollups are refreshed; 0 disables it
+	RollupInterval		time.Duration
+	// where report inputs are archived, if anywhere
+	Archive				archiveConfig
+}
+
+// archiveConfig selects where report inputs are archived
+type archiveConfig struct {
+	// ARCHIVE_NONE, ARCHIVE_DIR or ARCHIVE_MYSQL
+	Kind	string
+	// for ARCHIVE_DIR
+	Dir		string
+}
+
+func loadConfig() (config, error) {
+	var c config
+	var err error
+
+	c.LogLevel = os.Getenv("LOG_LEVEL")
+	c.ListenAddr = envString("LISTEN_ADDR", ":8001")
+	if c.ReadTimeout, err = envDuration("READ_TIMEOUT", 10*time.Second); err != nil {
+		return c, err
+	}
+	if c.WriteTimeout, err = envDuration("WRITE_TIMEOUT", 60*time.Second); err != nil {
+		return c, err
+	}
+	if c.IdleTimeout, err = envDuration("IDLE_TIMEOUT", 120*time.Second); err != nil {
+		return c, err
+	}
+	if c.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 30*time.Second); err != nil {
+		return c, err
+	}
+	if c.ReadyCheckUpstreams, err = envBool("READY_CHECK_UPSTREAMS", false); err != nil {
+		return c, err
+	}
+
+	if c.TrustProxyHeaders, err = envBool("TRUST_PROXY_HEADERS", false); err != nil {
+		return c, err
+	}
+
+	// ADMIN_API_KEYS=principal:key,...
+	if c.Auth.APIKeys, err = envPairs("ADMIN_API_KEYS", false); err != nil {
+		return c, err
+	}
+	// ADMIN_API_KEY_ROLES=principal:role|role,...
+	keyRoles, err := envPairs("ADMIN_API_KEY_ROLES", false)
+	if err != nil {
+		return c, err
+	}
+	c.Auth.APIKeyRoles = make(map[string][]auth.Role, len(keyRoles))
+	for principal, names := range keyRoles {
+		for _, name := range strings.Split(names, "|") {
+			role, err := auth.ParseRole(name)
+			if err != nil {
+				return c, fmt.Errorf("config: ADMIN_API_KEY_ROLES: %v", err)
+			}
+			c.Auth.APIKeyRoles[principal] = append(c.Auth.APIKeyRoles[principal], role)
+		}
+	}
+	// JWT_HMAC_KEYS=kid:secret,... a bare secret is registered without a kid
+	jwtKeys, err := envPairs("JWT_HMAC_KEYS", true)
+	if err != nil {
+		return c, err
+	}
+	c.Auth.JWTKeys = make(map[string][]byte, len(jwtKeys))
+	for kid, secret := range jwtKeys {
+		c.Auth.JWTKeys[kid] = []byte(secret)
+	}
+	c.Auth.JWTIssuer = os.Getenv("JWT_ISSUER")
+	c.Auth.JWTAudience = os.Getenv("JWT_AUDIENCE")
+	c.Auth.JWTRolesClaim = os.Getenv("JWT_ROLES_CLAIM")
+
+	// any origin unless restricted, as before the policy was configurable
+	c.CORS.AllowedOrigins = envList("CORS_ALLOWED_ORIGINS", []string{"*"})
+	c.CORS.AllowedMethods = envList("CORS_ALLOWED_METHODS",
+		[]string{"GET", "POST", "DELETE"})
+	c.CORS.AllowedHeaders = envList("CORS_ALLOWED_HEADERS",
+		[]string{"Accept", "Authorization", "Content-Type", "X-API-Key", REQUEST_ID_HEADER})
+	if c.CORS.AllowCredentials, err = envBool("CORS_ALLOW_CREDENTIALS", false); err != nil {
+		return c, err
+	}
+	// credentials for every origin would let any site act as the caller
+	if c.CORS.AllowCredentials && slices.Contains(c.CORS.AllowedOrigins, "*") {
+		return c, fmt.Errorf("config: CORS_ALLOW_CREDENTIALS needs CORS_ALLOWED_ORIGINS to list the allowed origins instead of *")
+	}
+	if c.CORS.MaxAge, err = envDuration("CORS_MAX_AGE", 10*time.Minute); err != nil {
+		return c, err
+	}
+
+	// report creation fetches the whole upstream dataset, keep it scarce;
+	// RATE_LIMIT_<ROUTE>_GLOBAL / _CLIENT=count/period or off
+	c.RateLimits = make(map[string]routeLimits)
+	for _, kind := range registry.All {
+		route := createRoute(kind.Name())
+		var limits routeLimits
+		prefix := "RATE_LIMIT_" + strings.ToUpper(route)
+		if limits.Global, err = envLimit(prefix+"_GLOBAL", "30/1m"); err != nil {
+			return c, err
+		}
+		if limits.Client, err = envLimit(prefix+"_CLIENT", "5/1m"); err != nil {
+			return c, err
+		}
+		c.RateLimits[route] = limits
+	}
+	// RATE_LIMIT_API_KEYS=principal:count/period,...
+	keyLimits, err := envPairs("RATE_LIMIT_API_KEYS", false)
+	if err != nil {
+		return c, err
+	}
+	c.RateLimitKeys = make(map[string]ratelimit.Limit, len(keyLimits))
+	for principal, value := range keyLimits {
+		if c.RateLimitKeys[principal], err = ratelimit.ParseLimit(value); err != nil {
+			return c, fmt.Errorf("config: RATE_LIMIT_API_KEYS: %v", err)
+		}
+	}
+
+	if c.AccountsURL, c.AccountsPaging, err = sources.LoadAccounts(); err != nil {
+		return c, err
+	}
+
+	// <NAME>_SOURCE, _URL, _FILE, _SQL_DSN, _SQL_QUERY, _PAGINATION and
+	// _PAGE_SIZE for habits and tasks
+	c.Sources = make(map[string]sources.Config)
+	for _, name := range sources.Names {
+		if c.Sources[name], err = sources.Load(name); err != nil {
+			return c, err
+		}
+	}
+
+	if c.RetentionInterval, err = envDuration("RETENTION_INTERVAL", 0); err != nil {
+		return c, err
+	}
+	if c.RetentionDryRun, err = envBool("RETENTION_DRY_RUN", false); err != nil {
+		return c, err
+	}
+	// RETENTION_DAILY_DAYS, RETENTION_MONTHLY_DAYS, RETENTION_PURGE_DAYS,
+	// each overridable per kind as RETENTION_<KIND>_DAILY_DAYS etc.; 0 keeps
+	// forever
+	defaults := make(map[string]int)
+	for setting, fallback := range map[string]int{"DAILY": 90, "MONTHLY": 0, "PURGE": 0} {
+		if defaults[setting], err = envInt("RETENTION_" + setting + "_DAYS", fallback); err != nil {
+			return c, err
+		}
+	}
+	c.Retention = make(map[string]report.Retention)
+	for _, entry := range registry.All {
+		kind := entry.Name()
+		days := make(map[string]int)
+		for setting, fallback := range defaults {
+			name := "RETENTION_" + strings.ToUpper(kind) + "_" + setting + "_DAYS"
+			if days[setting], err = envInt(name, fallback); err != nil {
+				return c, err
+			}
+			if days[setting] < 0 {
+				return c, fmt.Errorf("config: %s must not be negative", name)
+			}
+		}
+		c.Retention[kind] = report.Retention{
+			Daily:		time.Duration(days["DAILY"]) * 24 * time.Hour,
+			Monthly:	time.Duration(days["MONTHLY"]) * 24 * time.Hour,
+			Purge:		time.Duration(days["PURGE"]) * 24 * time.Hour,
+		}
+	}
+
+	if c.RollupInterval, err = envDuration("ROLLUP_INTERVAL", time.Hour); err != nil {
+		return c, err
+	}
+
+	c.Archive = archiveConfig{
+		Kind:	envString("ARCHIVE_INPUTS", ARCHIVE_NONE),
+		Dir:	envString("ARCHIVE_DIR", "archive"),
+	}
+	if c.Archive.Kind != ARCHIVE_NONE && c.Archive.Kind != ARCHIVE_DIR && c.Archive.Kind != ARCHIVE_MYSQL {
+		return c, fmt.Errorf("config: ARCHIVE_INPUTS must be %s, %s or %s, got %q",
+			ARCHIVE_NONE, ARCHIVE_DIR, ARCHIVE_MYSQL, c.Archive.Kind)
+	}
+	return c, nil
+}
+
+func envLimit(name, fallback string) (ratelimit.Limit, error) {
+	limit, err := ratelimit.ParseLimit(envString(name, fallback))
+	if err != nil {
+		return limit, fmt.Errorf("config: %s: %v", name, err)
+	}
+	return limit, nil
+}
+
+// envList parses a comma separated list, dropping empty entries
+func envList(name string, fallback []string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// envPairs parses a comma separated list of name:value entries. The value
+// may itself contain colons. With bareValues, an entry without a colon is
+// stored under the empty name.
+func envPairs(name string, bareValues bool) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv(name), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, value, found := strings.Cut(entry, ":")
+		if !found {
+			if !bareValues {
+				return nil, fmt.Errorf("config: %s entries must look like name:value", name)
+			}
+			key, value = "", entry
+		}
+		if _, duplicate := pairs[key]; duplicate {
+			return nil, fmt.Errorf("config: %s lists %q twice", name, key)
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback, fmt.Errorf("config: %s must be a duration such as 30s, got %q", name, value)
+	}
+	return d, nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback, fmt.Errorf("config: %s must be an integer, got %q", name, value)
+	}
+	return i, nil
+}
+
+func envBool(name string, fallback bool) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback, fmt.Errorf("config: %s must be a boolean, got %q", name, value)
+	}
+	return b, nil
+}
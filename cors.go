@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsConfig is the cross-origin policy applied to the /admin API
+type corsConfig struct {
+	// "*" allows any origin
+	AllowedOrigins		[]string
+	AllowedMethods		[]string
+	AllowedHeaders		[]string
+	AllowCredentials	bool
+	// how long browsers may cache a preflight answer
+	MaxAge				time.Duration
+}
+
+// response headers browser scripts may read
+var corsExposedHeaders = strings.Join([]string{
+	REQUEST_ID_HEADER, "X-Correlation-ID", "Location", "Retry-After",
+}, ", ")
+
+type corsPolicy struct {
+	config		corsConfig
+	anyOrigin	bool
+	origins		map[string]bool
+	methods		map[string]bool
+	headers		map[string]bool
+}
+
+func newCORSPolicy(config corsConfig) *corsPolicy {
+	p := &corsPolicy{
+		config:  config,
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			p.anyOrigin = true
+		}
+		p.origins[strings.ToLower(origin)] = true
+	}
+	for _, method := range config.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range config.AllowedHeaders {
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return p
+}
+
+func (p *corsPolicy) originAllowed(origin string) bool {
+	return p.anyOrigin || p.origins[strings.ToLower(origin)]
+}
+
+func (p *corsPolicy) headersAllowed(requested string) bool {
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !p.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+	return true
+}
+
+// set the origin related headers shared by preflight and actual responses;
+// loadConfig rejects any origin together with credentials
+func (p *corsPolicy) allowOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.config.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// cors applies the policy to requests under pathPrefix and answers their
+// preflight requests itself, before routing and authentication, since
+// browsers never send credentials on a preflight
+func (p *corsPolicy) cors(pathPrefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, pathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+			// a refused preflight carries no CORS headers, the browser
+			// then blocks the actual request
+			if p.originAllowed(origin) && p.methods[strings.ToUpper(requestedMethod)] &&
+				p.headersAllowed(requestedHeaders) {
+				p.allowOrigin(h, origin)
+				h.Set("Access-Control-Allow-Methods", strings.Join(p.config.AllowedMethods, ", "))
+				if requestedHeaders != "" {
+					h.Set("Access-Control-Allow-Headers", strings.Join(p.config.AllowedHeaders, ", "))
+				}
+				if p.config.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.config.MaxAge.Seconds())))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if p.originAllowed(origin) {
+			p.allowOrigin(h, origin)
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
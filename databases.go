@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github/godspeedkil/admin-report/audit"
+	"github/godspeedkil/admin-report/registry"
+	"log/slog"
+)
+
+// a database opened at startup and closed once the server has drained
+type database struct {
+	name	string
+	open	func(*slog.Logger) error
+	close	func() error
+}
+
+// one per report kind, then the audit log
+var databases = func() []database {
+	var dbs []database
+	for _, kind := range registry.All {
+		dbs = append(dbs, database{kind.Name(), kind.OpenDB, kind.CloseDB})
+	}
+	return append(dbs, database{"audit", audit.Init, func() error { return audit.DB.Close() }})
+}()
+
+// openDatabases opens every database in order; if one fails, those already
+// open are closed again
+func openDatabases(logger *slog.Logger) error {
+	for i, db := range databases {
+		if err := db.open(logger); err != nil {
+			logger.Error("could not open database", "database", db.name, "error", err)
+			closeDatabases(logger, databases[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// closeDatabases reports whether every database closed cleanly
+func closeDatabases(logger *slog.Logger, dbs []database) bool {
+	ok := true
+	for _, db := range dbs {
+		if err := db.close(); err != nil {
+			logger.Error("could not close database", "database", db.name, "error", err)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github/godspeedkil/admin-report/report"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_CSV = "csv"
+	FORMAT_HTML = "html"
+	FORMAT_PDF = "pdf"
+	DEFAULT_PAGE_SIZE = 50
+	MAX_PAGE_SIZE = 500
+)
+
+// media type served for each format
+var formatMediaTypes = map[string]string{
+	FORMAT_JSON: "application/json",
+	FORMAT_CSV:  "text/csv",
+	FORMAT_HTML: "text/html",
+	FORMAT_PDF:  "application/pdf",
+}
+
+// responseFormat picks the representation for r among supported, the
+// first of which is the default. An explicit ?format= wins over the
+// Accept header.
+func responseFormat(r *http.Request, supported ...string) (string, *appError) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, s := range supported {
+			if strings.EqualFold(format, s) {
+				return s, nil
+			}
+		}
+		return "", badRequestf(fmt.Errorf("unsupported format %q", format),
+			"format must be one of %s", strings.Join(supported, ", "))
+	}
+
+	best, bestQuality := supported[0], 0.0
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		for _, s := range supported {
+			if mediaType == formatMediaTypes[s] && quality > bestQuality {
+				best, bestQuality = s, quality
+			}
+		}
+	}
+	return best, nil
+}
+
+// writeCSV sends header and records as a CSV attachment named filename,
+// with formula-like cells escaped
+func writeCSV(w http.ResponseWriter, filename string, header []string, records [][]string) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": filename}))
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	for _, record := range records {
+		writer.Write(report.CSVEscapeRecord(record))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// pagination reads ?limit= and ?offset= for report listings
+func pagination(r *http.Request) (limit, offset int, e *appError) {
+	limit, offset = DEFAULT_PAGE_SIZE, 0
+	query := r.URL.Query()
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > MAX_PAGE_SIZE {
+			return 0, 0, badRequestf(fmt.Errorf("invalid limit %q", value),
+				"limit must be between 1 and %d", MAX_PAGE_SIZE)
+		}
+		limit = n
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, badRequestf(fmt.Errorf("invalid offset %q", value),
+				"offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
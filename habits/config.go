@@ -1,31 +1,4 @@
 package habits
 
-import "log"
-
+// DB holds the habits reports; opened at startup by whoever serves them
 var DB HabitsReportDatabase
-
-func init() {
-	var err error
-
-	DB, err = configureCloudSQL(cloudSQLConfig{
-		Username: "root",
-		Password: "admin",
-	})
-
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-type cloudSQLConfig struct {
-	Username, Password string
-}
-
-func configureCloudSQL(config cloudSQLConfig) (HabitsReportDatabase, error) {
-	return newMySQLDB(MySQLConfig{
-		Username: 	config.Username,
-		Password: 	config.Password,
-		Host:		"localhost",
-		Port:		3306,
-	})
-}
\ No newline at end of file
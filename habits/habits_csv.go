@@ -0,0 +1,43 @@
+package habits
+
+import (
+	"strconv"
+	"time"
+)
+
+// CSVHeader names the columns written by CSVRecord
+var CSVHeader = []string{
+	"report_id", "generated_at", "valid_records", "invalid_records",
+	"red", "orange", "yellow", "green", "blue",
+	"worst_user", "worst_title", "best_user", "best_title",
+}
+
+// CSVRecord flattens the report into one row matching CSVHeader
+func (r *HabitsReport) CSVRecord() []string {
+	var generatedAt string
+	if r.GeneratedAt != nil {
+		generatedAt = r.GeneratedAt.UTC().Format(time.RFC3339)
+	}
+	var validRecords, invalidRecords string
+	if r.DataQuality != nil {
+		validRecords = strconv.Itoa(r.DataQuality.Valid)
+		invalidRecords = strconv.Itoa(r.DataQuality.Invalid)
+	}
+	return []string{
+		strconv.FormatInt(r.ReportID, 10), generatedAt, validRecords, invalidRecords,
+		strconv.Itoa(r.RangeCount.Red), strconv.Itoa(r.RangeCount.Orange),
+		strconv.Itoa(r.RangeCount.Yellow), strconv.Itoa(r.RangeCount.Green),
+		strconv.Itoa(r.RangeCount.Blue),
+		// upstream strings, left as they are; CSV writers escape formulas
+		describe(r.Worst).User, describe(r.Worst).Title,
+		describe(r.Best).User, describe(r.Best).Title,
+	}
+}
+
+// a missing description flattens to empty cells
+func describe(d *HabitDescription) HabitDescription {
+	if d == nil {
+		return HabitDescription{}
+	}
+	return *d
+}
@@ -1,16 +1,17 @@
 package habits
 
 import (
-	"net/http"
-	"errors"
-	"encoding/json"
+	"context"
 	"fmt"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/report"
+	"time"
 )
 
 const (
 	//HABITS_URL = "https://api.myjson.com/bins/1end73"
 	HABITS_URL = "https://habits-microservice-marcorob.c9users.io"
-	//ACCOUNTS_URL = "http..."
 	COLOR_RED = "red darken-1"
 	COLOR_ORANGE = "orange darken-1"
 	COLOR_YELLOW = "yellow darken-2"
@@ -44,86 +45,140 @@ type HabitRange struct {
 type HabitsReport struct {
 	ReportID		int64				`json:"reportID"`
 	RangeCount 		HabitRange			`json:"rangeCount"`
-	Worst 			HabitDescription	`json:"worst"`
-	Best	 		HabitDescription	`json:"best"`
+	// nil, null in JSON, when the report covers no habits
+	Worst 			*HabitDescription	`json:"worst"`
+	Best	 		*HabitDescription	`json:"best"`
+	// nil for reports stored before generation times were recorded
+	GeneratedAt		*time.Time			`json:"generatedAt,omitempty"`
+	// nil for reports stored before records were validated
+	DataQuality		*report.DataQuality	`json:"dataQuality,omitempty"`
 }
 
-type HabitsReportDatabase interface {
-	AddHabitsReport(*HabitsReport) (reportId int64, err error)
+type HabitsReportDatabase = report.Database[HabitsReport]
 
-	GetHabitsReport(reportId int64)	(*HabitsReport, error)
+// Kind generates habits reports from Source
+var Kind = report.Kind[Habit, HabitsReport]{
+	Name:			"habits",
+	Fetch:			StreamHabits,
+	NewAggregator:	func() report.Aggregator[Habit, HabitsReport] { return NewAggregator() },
+	Schema:			schema,
+}
 
-	Close()
+// PingUpstream checks that Source can be read
+func PingUpstream(ctx context.Context) error {
+	return Source.Ping(ctx)
 }
 
-func fetchDatabaseAllHabits() ([]Habit, error) {
-	resp, err := http.Get(HABITS_URL + "/habits")
-	if err != nil {
-		return []Habit{}, errors.New("Habits unavailable")
-	}
+// StreamHabits passes every habit in Source to yield as it is read
+func StreamHabits(ctx context.Context, yield func(Habit) error) (err error) {
+	logger := logging.FromContext(ctx).With("upstream", "habits")
+	start := time.Now()
+	defer func() {
+		metrics.ObserveUpstreamFetch("habits", start, err)
+	}()
 
-	jsonArray := make([]Habit, 0)
-	decoder := json.NewDecoder(resp.Body)
-	defer resp.Body.Close()
-	if err = decoder.Decode(&jsonArray); err != nil {
-		return []Habit{}, errors.New("Error decoding Habits")
+	count, err := Source.StreamHabits(ctx, yield)
+	if err != nil {
+		logger.Warn("upstream fetch failed", "count", count, "error", err)
+		return fmt.Errorf("Habits unavailable: %v", err)
 	}
 
-	fmt.Println(jsonArray)
-
-	return jsonArray, nil
+	logger.Debug("upstream fetch complete", "count", count,
+		"duration", time.Since(start))
+	return nil
 }
 
-func createHabitRange(allHabits []Habit) HabitRange {
-	var habitRange HabitRange
-	for i, _ := range allHabits {
-		if allHabits[i].Color == COLOR_RED {
-			habitRange.Red++
-		} else if allHabits[i].Color == COLOR_ORANGE {
-			habitRange.Orange++
-		} else if allHabits[i].Color == COLOR_YELLOW {
-			habitRange.Yellow++
-		} else if allHabits[i].Color == COLOR_GREEN {
-			habitRange.Green++
-		} else if allHabits[i].Color == COLOR_BLUE {
-			habitRange.Blue++
-		}
+// FetchDatabaseAllHabits returns every habit in Source;
+// prefer StreamHabits where the habits need not all be held at once
+func FetchDatabaseAllHabits(ctx context.Context) ([]Habit, error) {
+	allHabits := make([]Habit, 0)
+	err := StreamHabits(ctx, func(habit Habit) error {
+		allHabits = append(allHabits, habit)
+		return nil
+	})
+	if err != nil {
+		return []Habit{}, err
 	}
-	return habitRange
+	return allHabits, nil
 }
 
-func findWorstHabit(allHabits []Habit) HabitDescription {
-	currentWorst := allHabits[0]
-	for i, _ := range allHabits {
-		if allHabits[i].Score < currentWorst.Score {
-			currentWorst = allHabits[i]
-		}
-	}
+// Aggregator builds a habits report one habit at a time
+type Aggregator struct {
+	habitRange	HabitRange
+	worst		*Habit
+	best		*Habit
+	quality		report.DataQuality
+}
 
-	return HabitDescription{currentWorst.UserID, currentWorst.Title}
+func NewAggregator() *Aggregator {
+	return &Aggregator{quality: report.NewDataQuality()}
 }
 
-func findBestHabit(allHabits []Habit) HabitDescription {
-	currentBest := allHabits[0]
-	for i, _ := range allHabits {
-		if allHabits[i].Score > currentBest.Score {
-			currentBest = allHabits[i]
-		}
+// Add counts a valid habit; invalid ones are only recorded in the data
+// quality section
+func (a *Aggregator) Add(habit Habit) {
+	if !a.quality.Record(habit.HabitID, habit.Validate()) {
+		return
+	}
+	if habit.Color == COLOR_RED {
+		a.habitRange.Red++
+	} else if habit.Color == COLOR_ORANGE {
+		a.habitRange.Orange++
+	} else if habit.Color == COLOR_YELLOW {
+		a.habitRange.Yellow++
+	} else if habit.Color == COLOR_GREEN {
+		a.habitRange.Green++
+	} else if habit.Color == COLOR_BLUE {
+		a.habitRange.Blue++
 	}
 
-	return HabitDescription{currentBest.UserID, currentBest.Title}
+	// ties keep the habit seen first
+	if a.worst == nil || habit.Score < a.worst.Score {
+		worst := habit
+		a.worst = &worst
+	}
+	if a.best == nil || habit.Score > a.best.Score {
+		best := habit
+		a.best = &best
+	}
 }
 
-func GenerateHabitsReport() (HabitsReport, error) {
+func (a *Aggregator) Report() HabitsReport {
 	var habitsReport HabitsReport
-	allHabits, err := fetchDatabaseAllHabits()
-	if err != nil {
-		return habitsReport, err
+	generatedAt := time.Now().UTC()
+	habitsReport.GeneratedAt = &generatedAt
+	habitsReport.RangeCount = a.habitRange
+	if a.worst != nil {
+		habitsReport.Worst = &HabitDescription{a.worst.UserID, a.worst.Title}
+	}
+	if a.best != nil {
+		habitsReport.Best = &HabitDescription{a.best.UserID, a.best.Title}
 	}
+	quality := a.quality
+	habitsReport.DataQuality = &quality
+
+	recordLatestHabitsReport(habitsReport)
+	return habitsReport
+}
 
-	habitsReport.RangeCount = createHabitRange(allHabits)
-	habitsReport.Worst = findWorstHabit(allHabits)
-	habitsReport.Best = findBestHabit(allHabits)
+func GenerateHabitsReport(ctx context.Context) (HabitsReport, error) {
+	return Kind.Generate(ctx)
+}
+
+// BuildHabitsReport aggregates already fetched habits
+func BuildHabitsReport(allHabits []Habit) HabitsReport {
+	aggregator := NewAggregator()
+	for i := range allHabits {
+		aggregator.Add(allHabits[i])
+	}
+	return aggregator.Report()
+}
 
-	return habitsReport, nil
+// expose the color buckets of the latest report as gauges
+func recordLatestHabitsReport(report HabitsReport) {
+	metrics.HabitsLatestRange.WithLabelValues("red").Set(float64(report.RangeCount.Red))
+	metrics.HabitsLatestRange.WithLabelValues("orange").Set(float64(report.RangeCount.Orange))
+	metrics.HabitsLatestRange.WithLabelValues("yellow").Set(float64(report.RangeCount.Yellow))
+	metrics.HabitsLatestRange.WithLabelValues("green").Set(float64(report.RangeCount.Green))
+	metrics.HabitsLatestRange.WithLabelValues("blue").Set(float64(report.RangeCount.Blue))
 }
\ No newline at end of file
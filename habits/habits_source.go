@@ -0,0 +1,104 @@
+package habits
+
+import (
+	"context"
+	"database/sql"
+	"github/godspeedkil/admin-report/upstream"
+	"os"
+)
+
+// HabitSource streams habits from wherever they are kept
+type HabitSource interface {
+	// StreamHabits hands every habit to yield as it is read, stopping at the
+	// first error yield returns; count is the number read
+	StreamHabits(ctx context.Context, yield func(Habit) error) (count int, err error)
+
+	// Ping checks that the habits can be read at all
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// Source is where reports read habits from; set from the configuration at
+// startup
+var Source HabitSource = HTTPSource{URL: HABITS_URL}
+
+// HTTPSource reads the habits service
+type HTTPSource struct {
+	URL		string
+	Paging	upstream.Paging
+}
+
+func (s HTTPSource) StreamHabits(ctx context.Context, yield func(Habit) error) (int, error) {
+	return upstream.Stream(ctx, s.URL + "/habits", s.Paging, yield)
+}
+
+func (s HTTPSource) Ping(ctx context.Context) error {
+	return upstream.Ping(ctx, s.URL)
+}
+
+func (s HTTPSource) Close() error {
+	return nil
+}
+
+// FileSource reads a JSON array or NDJSON export of the habits service
+type FileSource struct {
+	Path	string
+}
+
+func (s FileSource) StreamHabits(ctx context.Context, yield func(Habit) error) (int, error) {
+	return upstream.StreamFile(ctx, s.Path, yield)
+}
+
+func (s FileSource) Ping(ctx context.Context) error {
+	_, err := os.Stat(s.Path)
+	return err
+}
+
+func (s FileSource) Close() error {
+	return nil
+}
+
+// SQLSource reads habits straight from a database in read-only
+// transactions. Query must return the columns of SQL_COLUMNS, in order.
+type SQLSource struct {
+	db		*sql.DB
+	query	string
+}
+
+func NewSQLSource(ctx context.Context, dsn, query string) (*SQLSource, error) {
+	db, err := upstream.OpenSQL(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLSource{db, query}, nil
+}
+
+func (s *SQLSource) StreamHabits(ctx context.Context, yield func(Habit) error) (int, error) {
+	return upstream.StreamRows(ctx, s.db, s.query, scanHabit, yield)
+}
+
+func (s *SQLSource) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLSource) Close() error {
+	return s.db.Close()
+}
+
+// columns an SQLSource query returns, in order
+const SQL_COLUMNS = "id, user_id, title, color, score, difficulty, type"
+const DEFAULT_SQL_QUERY = "SELECT " + SQL_COLUMNS + " FROM habits"
+
+func scanHabit(rows *sql.Rows) (Habit, error) {
+	var (
+		habit		Habit
+		difficulty	sql.NullString
+		habitType	sql.NullString
+	)
+	err := rows.Scan(&habit.HabitID, &habit.UserID, &habit.Title, &habit.Color,
+		&habit.Score, &difficulty, &habitType)
+	habit.Difficulty = difficulty.String
+	habit.Type = habitType.String
+	return habit, err
+}
@@ -0,0 +1,32 @@
+package habits
+
+// reasons a habit is left out of a report's metrics
+const (
+	REJECT_MISSING_ID = "missing_id"
+	REJECT_MISSING_USER_ID = "missing_user_id"
+	REJECT_UNKNOWN_COLOR = "unknown_color"
+)
+
+var knownColors = map[string]bool{
+	COLOR_RED: true,
+	COLOR_ORANGE: true,
+	COLOR_YELLOW: true,
+	COLOR_GREEN: true,
+	COLOR_BLUE: true,
+}
+
+// Validate returns every reason the habit cannot be trusted, none if it is
+// valid
+func (h Habit) Validate() []string {
+	var reasons []string
+	if h.HabitID == "" {
+		reasons = append(reasons, REJECT_MISSING_ID)
+	}
+	if h.UserID == "" {
+		reasons = append(reasons, REJECT_MISSING_USER_ID)
+	}
+	if !knownColors[h.Color] {
+		reasons = append(reasons, REJECT_UNKNOWN_COLOR)
+	}
+	return reasons
+}
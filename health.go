@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github/godspeedkil/admin-report/audit"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/registry"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	STATUS_OK = "ok"
+	STATUS_UNAVAILABLE = "unavailable"
+	READY_CHECK_TIMEOUT = 2 * time.Second
+)
+
+// a named probe of one dependency
+type dependencyCheck struct {
+	Name	string
+	Check	func(context.Context) error
+}
+
+type dependencyStatus struct {
+	Status		string	`json:"status"`
+	LatencyMs	float64	`json:"latencyMs"`
+}
+
+type readinessResponse struct {
+	Status			string						`json:"status"`
+	Dependencies	map[string]dependencyStatus	`json:"dependencies"`
+}
+
+// process is up and serving
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{"status": STATUS_OK})
+}
+
+// readyzHandler reports 200 only when every dependency answers within
+// READY_CHECK_TIMEOUT. Failure causes are logged, not returned.
+func readyzHandler(checks []dependencyCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), READY_CHECK_TIMEOUT)
+		defer cancel()
+
+		response := readinessResponse{
+			Status:       STATUS_OK,
+			Dependencies: make(map[string]dependencyStatus, len(checks)),
+		}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, check := range checks {
+			wg.Add(1)
+			go func(check dependencyCheck) {
+				defer wg.Done()
+				start := time.Now()
+				err := check.Check(ctx)
+				status := dependencyStatus{STATUS_OK,
+					float64(time.Since(start).Microseconds()) / 1000}
+				if err != nil {
+					status.Status = STATUS_UNAVAILABLE
+					logging.FromContext(ctx).Warn("dependency not ready",
+						"dependency", check.Name, "error", err)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				response.Dependencies[check.Name] = status
+				if err != nil {
+					response.Status = STATUS_UNAVAILABLE
+				}
+			}(check)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if response.Status != STATUS_OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// dependencies probed by /readyz
+func readinessChecks(cfg config) []dependencyCheck {
+	var checks []dependencyCheck
+	for _, kind := range registry.All {
+		checks = append(checks, dependencyCheck{"mysql_" + kind.Name(), kind.PingDB})
+	}
+	checks = append(checks,
+		dependencyCheck{"mysql_audit", func(ctx context.Context) error { return audit.DB.Ping(ctx) }})
+	if cfg.ReadyCheckUpstreams {
+		for _, kind := range registry.All {
+			if ping := kind.PingUpstream(); ping != nil {
+				checks = append(checks, dependencyCheck{kind.Name() + "_upstream", ping})
+			}
+		}
+	}
+	return checks
+}
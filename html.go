@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/tasks"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templateFuncs = template.FuncMap{
+	"formatTime": func(t time.Time) string {
+		return t.UTC().Format("2 Jan 2006 15:04 MST")
+	},
+}
+
+// one page template per report kind, each combined with the layout
+var (
+	habitsReportTemplate = template.Must(template.New("layout").Funcs(templateFuncs).
+		ParseFS(templateFiles, "templates/layout.html", "templates/data_quality.html",
+			"templates/habits_report.html"))
+	tasksReportTemplate = template.Must(template.New("layout").Funcs(templateFuncs).
+		ParseFS(templateFiles, "templates/layout.html", "templates/data_quality.html",
+			"templates/tasks_report.html"))
+)
+
+// fill colors matching the materialize classes the habits service uses
+var habitColorFills = []struct {
+	label, fill string
+}{
+	{"Red", "#e53935"},
+	{"Orange", "#fb8c00"},
+	{"Yellow", "#fbc02d"},
+	{"Green", "#7cb342"},
+	{"Blue", "#1e88e5"},
+}
+
+const (
+	CHART_WIDTH = 500
+	CHART_HEIGHT = 220
+	// room below the bars for labels
+	CHART_LABEL_HEIGHT = 24
+)
+
+type svgBar struct {
+	Label			string
+	Fill			string
+	Value			int
+	Percent			int
+	X, Y			float64
+	Width, Height	float64
+	LabelX, ValueY	float64
+}
+
+type svgChart struct {
+	Width, Height	int
+	LabelY			float64
+	Bars			[]svgBar
+}
+
+// barChart lays out one vertical bar per value, scaled to the largest
+func barChart(labels, fills []string, values []int) svgChart {
+	chart := svgChart{Width: CHART_WIDTH, Height: CHART_HEIGHT,
+		LabelY: CHART_HEIGHT - 6}
+	plotHeight := float64(CHART_HEIGHT - CHART_LABEL_HEIGHT - 16)
+	slot := float64(CHART_WIDTH) / float64(len(values))
+
+	max := 0
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	for i, v := range values {
+		height := 0.0
+		if max > 0 {
+			height = plotHeight * float64(v) / float64(max)
+		}
+		x := slot*float64(i) + slot*0.15
+		y := float64(CHART_HEIGHT-CHART_LABEL_HEIGHT) - height
+		chart.Bars = append(chart.Bars, svgBar{
+			Label: labels[i], Fill: fills[i], Value: v,
+			X: x, Y: y, Width: slot * 0.7, Height: height,
+			LabelX: x + slot*0.35, ValueY: y - 4,
+		})
+	}
+	return chart
+}
+
+// stackedBar lays out the values side by side on one horizontal bar, each
+// as wide as its share of the total
+func stackedBar(labels, fills []string, values []int) svgChart {
+	const barHeight = 40
+	chart := svgChart{Width: CHART_WIDTH, Height: barHeight + CHART_LABEL_HEIGHT,
+		LabelY: barHeight + 16}
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+
+	x := 0.0
+	for i, v := range values {
+		bar := svgBar{Label: labels[i], Fill: fills[i], Value: v, X: x, Height: barHeight}
+		if total > 0 {
+			bar.Width = float64(CHART_WIDTH) * float64(v) / float64(total)
+			bar.Percent = v * 100 / total
+		}
+		bar.LabelX = x + bar.Width/2
+		x += bar.Width
+		chart.Bars = append(chart.Bars, bar)
+	}
+	return chart
+}
+
+type habitsReportPage struct {
+	*habits.HabitsReport
+	Chart	svgChart
+}
+
+type tasksReportPage struct {
+	*tasks.TasksReport
+	Chart	svgChart
+}
+
+// habit color buckets as a bar chart, shared by the HTML and PDF views
+func habitsChart(report *habits.HabitsReport) svgChart {
+	var labels, fills []string
+	for _, color := range habitColorFills {
+		labels = append(labels, color.label)
+		fills = append(fills, color.fill)
+	}
+	r := report.RangeCount
+	return barChart(labels, fills, []int{r.Red, r.Orange, r.Yellow, r.Green, r.Blue})
+}
+
+// completed tasks split into on time and late
+func tasksChart(report *tasks.TasksReport) svgChart {
+	return stackedBar([]string{"On time", "Late"}, []string{"#7cb342", "#e53935"},
+		[]int{report.Completed.OnTime, report.Completed.Late})
+}
+
+func renderHabitsReportHTML(w http.ResponseWriter, report *habits.HabitsReport) error {
+	return writeHTML(w, habitsReportTemplate, habitsReportPage{report, habitsChart(report)})
+}
+
+func renderTasksReportHTML(w http.ResponseWriter, report *tasks.TasksReport) error {
+	return writeHTML(w, tasksReportTemplate, tasksReportPage{report, tasksChart(report)})
+}
+
+// writeHTML renders the whole page before sending anything, so a template
+// error can still become an error response
+func writeHTML(w http.ResponseWriter, t *template.Template, data interface{}) error {
+	var page bytes.Buffer
+	if err := t.ExecuteTemplate(&page, "layout", data); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the pages are self-contained: inline styles and SVG only
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	_, err := page.WriteTo(w)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// jobGroup runs background work under a context that is cancelled when the
+// server starts shutting down, and lets shutdown wait for it to finish
+type jobGroup struct {
+	ctx	context.Context
+	wg	sync.WaitGroup
+}
+
+func newJobGroup(ctx context.Context) *jobGroup {
+	return &jobGroup{ctx: ctx}
+}
+
+// Go runs job in its own goroutine; job must return soon after its context
+// is done
+func (g *jobGroup) Go(job func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		job(g.ctx)
+	}()
+}
+
+// Wait blocks until every job has returned or ctx is done
+func (g *jobGroup) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github/godspeedkil/admin-report/registry"
+	"net/http"
+)
+
+// kinds are how the report kinds of registry.All are served, in route
+// registration order; every registered kind needs an entry. Databases,
+// readiness, retention, rollups and rate limits follow registry.All.
+var kinds = []servedKind{
+	serve(registry.Habits, "/habits/reports", renderHabitsReportHTML, renderHabitsReportPDF),
+	serve(registry.Tasks, "/tasks/reports", renderTasksReportHTML, renderTasksReportPDF),
+	serve(registry.Accounts, "/accounts/reports", nil, nil),
+	serve(registry.Summary, "/reports/summary", nil, nil),
+}
+
+// servedKind is a registered kind together with its HTTP routes
+type servedKind interface {
+	registry.Kind
+	// register must be called once the database and the archive are open
+	register(admin *mux.Router, adminRoute adminRouteFunc, cfg config)
+}
+
+// createRoute is the RATE_LIMIT_* route name of report creation
+func createRoute(kind string) string {
+	return kind + "_create"
+}
+
+type kindEntry[R any] struct {
+	*registry.Entry[R]
+	// path below /admin the routes are registered at
+	path		string
+	// nil if the format is not available
+	renderHTML	func(http.ResponseWriter, *R) error
+	renderPDF	func(http.ResponseWriter, *R) error
+}
+
+func serve[R any](entry *registry.Entry[R], path string,
+	renderHTML, renderPDF func(http.ResponseWriter, *R) error) servedKind {
+	return &kindEntry[R]{entry, path, renderHTML, renderPDF}
+}
+
+func (e *kindEntry[R]) register(admin *mux.Router, adminRoute adminRouteFunc, cfg config) {
+	routes := reportRoutes[R]{
+		name:		e.ID,
+		path:		e.path,
+		rateLimit:	createRoute(e.ID),
+		db:			*e.DB,
+		generate:	e.Generate,
+		save:		e.Save,
+		csvHeader:	e.CSVHeader,
+		csvRecord:	e.CSVRecord,
+		renderHTML:	e.renderHTML,
+		renderPDF:	e.renderPDF,
+	}
+	if e.Archived {
+		routes.archive = inputArchive
+	}
+	routes.register(admin, adminRoute, cfg)
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"github.com/gorilla/mux"
-	"log"
+	"log/slog"
 	"net/http"
 	"fmt"
-	"github/godspeedkil/admin-report/habits"
-	"strconv"
+	"github/godspeedkil/admin-report/accounts"
+	"github/godspeedkil/admin-report/audit"
+	"github/godspeedkil/admin-report/auth"
 	"encoding/json"
-	"github/godspeedkil/admin-report/tasks"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
 )
 
 const (
@@ -17,115 +24,225 @@ const (
 )
 
 func main() {
-	registerHandlers()
-}
-
-func registerHandlers() {
-	router := mux.NewRouter()
-
-	router.Methods("GET").Path("/admin/habits/reports").
-		Handler(appHandler(createHabitsReportHandler))
-	router.Methods("GET").Path("/admin/habits/reports/{reportId}").
-		Handler(appHandler(getHabitsReportHandler))
-	router.Methods("GET").Path("/admin/tasks/reports").
-		Handler(appHandler(createTasksReportHandler))
-	router.Methods("GET").Path("/admin/tasks/reports/{reportId}").
-		Handler(appHandler(getTasksReportHandler))
-
-	log.Fatal(http.ListenAndServe(":8001", router))
-}
-
-func getHabitsReportHandler(w http.ResponseWriter, r *http.Request) *appError {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
-	vars := mux.Vars(r)
-	reportId, err := strconv.ParseInt(vars["reportId"], DECIMAL_BASE, INT64_BITS)
+	cfg, err := loadConfig()
 	if err != nil {
-		return appErrorf(err, "could not parse reportId from request: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	report, err := habits.DB.GetHabitsReport(reportId)
+	logger, err := logging.New(os.Stderr, cfg.LogLevel)
 	if err != nil {
-		return appErrorf(err, "could not get report: %v", err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	json.NewEncoder(w).Encode(report)
-	return nil
-}
-
-func createHabitsReportHandler(w http.ResponseWriter, r *http.Request) *appError {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	slog.SetDefault(logger)
+	accounts.URL = cfg.AccountsURL
+	accounts.Paging = cfg.AccountsPaging
 
-	report, err := habits.GenerateHabitsReport()
+	authenticator, err := auth.New(cfg.Auth)
 	if err != nil {
-		return appErrorf(err, "could not generate habits report: %v", err)
+		logger.Error("invalid auth configuration", "error", err)
+		os.Exit(1)
+	}
+	if !authenticator.Enabled() {
+		logger.Warn("no API keys or JWT keys configured, every /admin request will be rejected")
 	}
 
-	reportId, err := habits.DB.AddHabitsReport(&report)
-	if err != nil {
-		return appErrorf(err, "could not add report to db: %v", err)
+	if err := openSources(context.Background(), cfg, logger); err != nil {
+		logger.Error("could not open upstream sources", "error", err)
+		os.Exit(1)
+	}
+	if err := openDatabases(logger); err != nil {
+		closeSources(logger)
+		os.Exit(1)
+	}
+	if err := openArchive(cfg, logger); err != nil {
+		logger.Error("could not open input archive", "error", err)
+		closeDatabases(logger, databases)
+		closeSources(logger)
+		os.Exit(1)
 	}
-	http.Redirect(w, r, fmt.Sprintf("/admin/habits/reports/%d", reportId),
-		http.StatusFound)
-	return nil
-}
 
-func getTasksReportHandler(w http.ResponseWriter, r *http.Request) *appError {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
-	vars := mux.Vars(r)
-	reportId, err := strconv.ParseInt(vars["reportId"], DECIMAL_BASE, INT64_BITS)
-	if err != nil {
-		return appErrorf(err, "could not parse reportId from request: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	jobs := newJobGroup(ctx)
+	if cfg.RetentionInterval > 0 {
+		targets := pruneTargets()
+		jobs.Go(func(ctx context.Context) {
+			runPruner(ctx, logger, cfg.RetentionInterval, cfg.RetentionDryRun,
+				cfg.Retention, targets)
+		})
 	}
-	report, err := tasks.DB.GetTasksReport(reportId)
-	if err != nil {
-		return appErrorf(err, "could not get report: %v", err)
+	if cfg.RollupInterval > 0 {
+		targets := rollupTargets()
+		jobs.Go(func(ctx context.Context) {
+			runRollups(ctx, logger, cfg.RollupInterval, targets)
+		})
+	}
+
+	server := &http.Server{
+		Addr:			cfg.ListenAddr,
+		Handler:		registerHandlers(cfg, logger, authenticator),
+		ReadTimeout:	cfg.ReadTimeout,
+		WriteTimeout:	cfg.WriteTimeout,
+		IdleTimeout:	cfg.IdleTimeout,
+		ErrorLog:		slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", cfg.ListenAddr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		logger.Error("server stopped", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		logger.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("could not drain in-flight requests", "error", err)
+		exitCode = 1
+	}
+	if err := jobs.Wait(shutdownCtx); err != nil {
+		logger.Error("could not drain background jobs", "error", err)
+		exitCode = 1
 	}
-	json.NewEncoder(w).Encode(report)
-	return nil
+	if !closeDatabases(logger, databases) {
+		exitCode = 1
+	}
+	if !closeArchive(logger) {
+		exitCode = 1
+	}
+	if !closeSources(logger) {
+		exitCode = 1
+	}
+	logger.Info("shutdown complete")
+	os.Exit(exitCode)
 }
 
-func createTasksReportHandler(w http.ResponseWriter, r *http.Request) *appError {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
+func registerHandlers(cfg config, logger *slog.Logger, authenticator *auth.Authenticator) http.Handler {
+	router := mux.NewRouter()
 
-	report, err := tasks.GenerateTasksReport()
-	if err != nil {
-		return appErrorf(err, "could not generate tasks report: %v", err)
+	// audit outermost so that calls rejected by authentication or the role
+	// check are recorded too
+	adminRoute := func(action audit.Action, reportType string, role auth.Role, h http.Handler) http.Handler {
+		return audited(action, reportType, cfg.TrustProxyHeaders,
+			authenticate(authenticator)(requireRole(role, h)))
 	}
+	admin := router.PathPrefix("/admin").Subrouter()
 
-	reportId, err := tasks.DB.AddTasksReport(&report)
-	if err != nil {
-		return appErrorf(err, "could not add report to db: %v", err)
+	// databases and the archive are open by now, so the DB values and
+	// inputArchive are final
+	for _, kind := range kinds {
+		kind.register(admin, adminRoute, cfg)
 	}
-	http.Redirect(w, r, fmt.Sprintf("/admin/tasks/reports/%d", reportId),
-		http.StatusFound)
-	return nil
+	admin.Methods("GET").Path("/reports/snapshot").
+		Handler(adminRoute(audit.ActionExport, "snapshot", auth.RoleViewer,
+			appHandler(snapshotPDFHandler)))
+	admin.Methods("GET").Path("/audit").
+		Handler(adminRoute(audit.ActionList, "audit", auth.RoleAdmin,
+			appHandler(listAuditHandler)))
+	router.Methods("GET").Path("/metrics").Handler(metrics.Handler())
+	router.Methods("GET").Path("/healthz").HandlerFunc(healthzHandler)
+	router.Methods("GET").Path("/readyz").HandlerFunc(readyzHandler(readinessChecks(cfg)))
+	router.Use(instrumentRoutes)
+
+	return requestLogger(logger, newCORSPolicy(cfg.CORS).cors("/admin/", router))
 }
 
 type appHandler func(http.ResponseWriter, *http.Request) *appError
 
+// appError separates what the client is allowed to see (Message, Code) from
+// the underlying cause (Error), which is only ever written to the logs.
 type appError struct {
 	Error	error
 	Message	string
 	Code	int
 }
 
+// body of every error response; CorrelationID is the request ID found on
+// the server's log lines for this request
+type errorResponse struct {
+	Error			string	`json:"error"`
+	CorrelationID	string	`json:"correlationID"`
+}
+
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	defer func() {
+		// net/http uses ErrAbortHandler to abort a response on purpose
+		if v := recover(); v != nil && v != http.ErrAbortHandler {
+			logging.FromContext(r.Context()).Error("handler panicked",
+				"panic", v, "stack", string(debug.Stack()))
+			writeError(w, r, appErrorf(fmt.Errorf("panic: %v", v), "internal error"))
+		} else if v != nil {
+			panic(v)
+		}
+	}()
 	if e := fn(w, r); e != nil {
-		log.Printf("Handler error: status code: %d, message: %s, underlying err: %#v",
-			e.Code, e.Message, e.Error)
+		writeError(w, r, e)
+	}
+}
 
-		http.Error(w, e.Message, e.Code)
+// writeError logs e with its cause and sends the client only the public
+// message and the request's correlation ID
+func writeError(w http.ResponseWriter, r *http.Request, e *appError) {
+	ctx := r.Context()
+	correlationId := logging.RequestID(ctx)
+	level := slog.LevelError
+	if e.Code < http.StatusInternalServerError {
+		level = slog.LevelWarn
 	}
+	logging.FromContext(ctx).Log(ctx, level, "handler error",
+		"status", e.Code, "message", e.Message, "error", e.Error)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Correlation-ID", correlationId)
+	w.WriteHeader(e.Code)
+	json.NewEncoder(w).Encode(errorResponse{e.Message, correlationId})
 }
 
+// format and v build the public message: never pass err into it, the cause
+// is logged separately under the correlation ID
 func appErrorf(err error, format string, v ...interface{}) *appError {
 	return &appError{
 		Error:   err,
 		Message: fmt.Sprintf(format, v...),
-		Code:    500,
+		Code:    http.StatusInternalServerError,
 	}
-}
\ No newline at end of file
+}
+
+func badRequestf(err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	e.Code = http.StatusBadRequest
+	return e
+}
+
+func notFoundf(err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	e.Code = http.StatusNotFound
+	return e
+}
+
+func unauthorizedf(err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	e.Code = http.StatusUnauthorized
+	return e
+}
+
+func forbiddenf(err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	e.Code = http.StatusForbidden
+	return e
+}
+
+func tooManyRequestsf(err error, format string, v ...interface{}) *appError {
+	e := appErrorf(err, format, v...)
+	e.Code = http.StatusTooManyRequests
+	return e
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "admin_report"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route template and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	UpstreamFetchDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "upstream_fetch_duration_seconds",
+		Help:      "Latency of fetching all records from an upstream service.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"upstream"})
+
+	UpstreamFetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "upstream_fetch_errors_total",
+		Help:      "Failed fetches from an upstream service.",
+	}, []string{"upstream"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of report database queries, by table and operation.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"table", "operation", "outcome"})
+
+	ReportGenerationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "report_generation_duration_seconds",
+		Help:      "Time taken to generate a report, upstream fetch included.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"kind", "outcome"})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected with 429, by route and the limit that was hit.",
+	}, []string{"route", "scope"})
+
+	HabitsLatestRange = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "habits_latest_range_count",
+		Help:      "Habits per color bucket in the most recently generated habits report.",
+	}, []string{"color"})
+
+	TasksLatest = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "tasks_latest_count",
+		Help:      "Task counts in the most recently generated tasks report.",
+	}, []string{"metric"})
+
+	ReportsPruned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "reports_pruned_total",
+		Help:      "Reports removed by the retention pruner, by kind and class (daily, monthly, purged); dry runs count what would have been removed.",
+	}, []string{"kind", "class", "dry_run"})
+
+	AccountsLatest = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "accounts_latest_count",
+		Help:      "Account counts in the most recently generated accounts report.",
+	}, []string{"metric"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// outcome label value for err
+func outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// ObserveUpstreamFetch records the latency since start of a fetch from
+// upstream, counting it as an error if err is not nil
+func ObserveUpstreamFetch(upstream string, start time.Time, err error) {
+	UpstreamFetchDuration.WithLabelValues(upstream).Observe(time.Since(start).Seconds())
+	if err != nil {
+		UpstreamFetchErrors.WithLabelValues(upstream).Inc()
+	}
+}
+
+func ObserveDBQuery(table, operation string, start time.Time, err error) {
+	DBQueryDuration.WithLabelValues(table, operation, outcome(err)).
+		Observe(time.Since(start).Seconds())
+}
+
+func ObserveReportGeneration(kind string, start time.Time, err error) {
+	ReportGenerationDuration.WithLabelValues(kind, outcome(err)).
+		Observe(time.Since(start).Seconds())
+}
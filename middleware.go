@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github/godspeedkil/admin-report/audit"
+	"github/godspeedkil/admin-report/auth"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	AUDIT_WRITE_TIMEOUT = 5 * time.Second
+)
+
+// accepted shape of a caller-supplied request ID
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusRecorder remembers the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// requestLogger assigns every request an ID (reusing a well-formed
+// X-Request-ID from the caller), stores it with a request-scoped logger in
+// the request context and logs one line per completed request.
+func requestLogger(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestId := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestId.MatchString(requestId) {
+			requestId = logging.NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, requestId)
+
+		ctx := logging.WithRequestID(logging.WithLogger(r.Context(), logger), requestId)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		logging.FromContext(ctx).Info("request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+			"remoteAddr", r.RemoteAddr)
+	})
+}
+
+// instrumentRoutes is a mux middleware counting requests and their latency
+// per registered route template, so ids in the path don't blow up the
+// label cardinality
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		metrics.HTTPRequestDuration.WithLabelValues(route, r.Method).
+			Observe(time.Since(start).Seconds())
+		metrics.HTTPRequests.WithLabelValues(route, r.Method,
+			strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// authenticate rejects requests without valid credentials with a 401 and
+// otherwise stores the caller in the request context
+func authenticate(authenticator *auth.Authenticator) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authenticator.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin-report"`)
+				writeError(w, r, unauthorizedf(err, "valid API key or bearer token required"))
+				return
+			}
+
+			audit.SetActor(r.Context(), principal.Name)
+			ctx := auth.WithPrincipal(r.Context(), principal)
+			ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With(
+				"principal", principal.Name, "authMethod", principal.Method))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requireRole answers 403 unless the authenticated caller holds role
+func requireRole(role auth.Role, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.PrincipalFromContext(r.Context())
+		if principal == nil {
+			writeError(w, r, unauthorizedf(auth.ErrNoCredentials, "valid API key or bearer token required"))
+			return
+		}
+		if !principal.HasRole(role) {
+			err := fmt.Errorf("auth: %s holds roles %v, %s required", principal.Name, principal.Roles, role)
+			writeError(w, r, forbiddenf(err, "this operation requires the %s role", role))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// audited records every call to the wrapped handler in the audit log once
+// it has completed. The actor and report ID are filled in further down the
+// chain through the entry stored in the request context.
+func audited(action audit.Action, reportType string, trustProxy bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := &audit.Entry{
+			Timestamp:  time.Now(),
+			Actor:      audit.ANONYMOUS,
+			Action:     action,
+			ReportType: reportType,
+			ClientIP:   clientIP(r, trustProxy),
+			RequestID:  logging.RequestID(r.Context()),
+		}
+		ctx := audit.WithEntry(r.Context(), entry)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		entry.Status = rec.status
+		entry.Outcome = audit.OutcomeForStatus(rec.status)
+		// the client may already be gone, the entry must still be written
+		writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), AUDIT_WRITE_TIMEOUT)
+		defer cancel()
+		if _, err := audit.DB.AddEntry(writeCtx, entry); err != nil {
+			logging.FromContext(ctx).Error("could not write audit entry",
+				"action", action, "reportType", reportType, "error", err)
+		}
+	})
+}
+
+// clientIP is the address of the peer, or with trustProxy the last
+// address of X-Forwarded-For, the one appended by the proxy in front of the
+// server; earlier entries come from the client and can be forged
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded) - 1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i + 1:]
+			}
+			if last = strings.TrimSpace(last); last != "" {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jung-kurt/gofpdf"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/tasks"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// page geometry in mm, A4 portrait
+const (
+	PDF_MARGIN = 20.0
+	PDF_CONTENT_WIDTH = 170.0
+	PDF_CHART_HEIGHT = 55.0
+)
+
+// reportPDF is a document being built with a shared look: title, sections
+// and a footer naming the report(s) it was made from
+type reportPDF struct {
+	*gofpdf.Fpdf
+	// converts UTF-8 to the core fonts' code page
+	text	func(string) string
+}
+
+func newReportPDF(title, footer string) *reportPDF {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	doc := &reportPDF{pdf, pdf.UnicodeTranslatorFromDescriptor("")}
+	pdf.SetMargins(PDF_MARGIN, PDF_MARGIN, PDF_MARGIN)
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("admin-report", true)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-15)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetTextColor(117, 117, 117)
+		pdf.CellFormat(PDF_CONTENT_WIDTH*0.8, 5, doc.text(footer), "", 0, "L", false, 0, "")
+		pdf.CellFormat(PDF_CONTENT_WIDTH*0.2, 5,
+			fmt.Sprintf("page %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.CellFormat(0, 10, doc.text(title), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+	return doc
+}
+
+func (doc *reportPDF) heading(text string) {
+	doc.SetFont("Helvetica", "B", 13)
+	doc.SetTextColor(33, 33, 33)
+	doc.CellFormat(0, 8, doc.text(text), "", 1, "L", false, 0, "")
+	doc.Ln(1)
+}
+
+// table writes label/value rows
+func (doc *reportPDF) table(rows [][2]string) {
+	doc.SetFont("Helvetica", "", 10)
+	doc.SetDrawColor(224, 224, 224)
+	for _, row := range rows {
+		doc.CellFormat(PDF_CONTENT_WIDTH*0.6, 6, doc.text(row[0]), "B", 0, "L", false, 0, "")
+		doc.CellFormat(PDF_CONTENT_WIDTH*0.4, 6, doc.text(row[1]), "B", 1, "R", false, 0, "")
+	}
+	doc.Ln(4)
+}
+
+func (doc *reportPDF) fill(hex string) {
+	r, g, b := hexColor(hex)
+	doc.SetFillColor(r, g, b)
+}
+
+// barChart draws the same layout as the HTML bar chart: one vertical bar
+// per value scaled to the largest, value above and label below
+func (doc *reportPDF) barChart(chart svgChart) {
+	_, top := doc.GetXY()
+	scaleX := PDF_CONTENT_WIDTH / float64(chart.Width)
+	scaleY := PDF_CHART_HEIGHT / float64(chart.Height)
+	doc.SetFont("Helvetica", "", 9)
+	for _, bar := range chart.Bars {
+		x := PDF_MARGIN + bar.X*scaleX
+		doc.fill(bar.Fill)
+		if bar.Height > 0 {
+			doc.Rect(x, top+bar.Y*scaleY, bar.Width*scaleX, bar.Height*scaleY, "F")
+		}
+		doc.Text(x, top+bar.ValueY*scaleY, strconv.Itoa(bar.Value))
+		doc.Text(x, top+chart.LabelY*scaleY, doc.text(bar.Label))
+	}
+	doc.SetY(top + PDF_CHART_HEIGHT + 4)
+}
+
+// stackedBar draws the shares of a horizontal stacked bar with a legend
+func (doc *reportPDF) stackedBar(chart svgChart) {
+	_, top := doc.GetXY()
+	scaleX := PDF_CONTENT_WIDTH / float64(chart.Width)
+	doc.SetFont("Helvetica", "", 9)
+	for _, bar := range chart.Bars {
+		if bar.Width > 0 {
+			doc.fill(bar.Fill)
+			doc.Rect(PDF_MARGIN+bar.X*scaleX, top, bar.Width*scaleX, 12, "F")
+		}
+	}
+	doc.SetY(top + 15)
+	for _, bar := range chart.Bars {
+		doc.fill(bar.Fill)
+		x, y := doc.GetXY()
+		doc.Rect(x, y+1, 3, 3, "F")
+		doc.SetX(x + 5)
+		doc.CellFormat(50, 5, doc.text(fmt.Sprintf("%s: %d (%d%%)", bar.Label, bar.Value, bar.Percent)),
+			"", 0, "L", false, 0, "")
+	}
+	doc.Ln(10)
+}
+
+func (doc *reportPDF) habitsSection(report *habits.HabitsReport) {
+	doc.heading("Habits by color")
+	doc.barChart(habitsChart(report))
+	doc.heading("Extremes")
+	doc.table([][2]string{
+		{"Best habit", habitLabel(report.Best)},
+		{"Worst habit", habitLabel(report.Worst)},
+	})
+	doc.dataQualitySection(report.DataQuality)
+}
+
+// dataQualitySection lists rejected records by reason, with sample IDs
+func (doc *reportPDF) dataQualitySection(quality *report.DataQuality) {
+	if quality == nil {
+		return
+	}
+	doc.heading("Data quality")
+	rows := [][2]string{
+		{"Valid records", strconv.Itoa(quality.Valid)},
+		{"Invalid records", strconv.Itoa(quality.Invalid)},
+	}
+	for _, reason := range quality.Reasons() {
+		rows = append(rows, [2]string{"   " + reason, fmt.Sprintf("%d (%s)",
+			quality.Rejected[reason], strings.Join(quality.Samples[reason], ", "))})
+	}
+	doc.table(rows)
+}
+
+func habitLabel(d *habits.HabitDescription) string {
+	if d == nil {
+		return "no habits"
+	}
+	return fmt.Sprintf("%s (%s)", d.Title, d.User)
+}
+
+func (doc *reportPDF) tasksSection(report *tasks.TasksReport) {
+	doc.heading("Completed tasks")
+	doc.stackedBar(tasksChart(report))
+	doc.table([][2]string{
+		{"Completed", strconv.Itoa(report.Completed.Total)},
+		{"   on time", strconv.Itoa(report.Completed.OnTime)},
+		{"   late", strconv.Itoa(report.Completed.Late)},
+		{"Delayed", strconv.Itoa(report.Delayed)},
+		{"Available", strconv.Itoa(report.Available.Total)},
+		{"   due today", strconv.Itoa(report.Available.DueToday)},
+	})
+	doc.dataQualitySection(report.DataQuality)
+}
+
+// describes a stored report for footers
+func reportLabel(kind string, reportId int64, generatedAt *time.Time) string {
+	label := fmt.Sprintf("%s report #%d", kind, reportId)
+	if generatedAt != nil {
+		label += ", generated " + generatedAt.UTC().Format("2006-01-02 15:04 MST")
+	}
+	return label
+}
+
+func renderHabitsReportPDF(w http.ResponseWriter, report *habits.HabitsReport) error {
+	doc := newReportPDF("Habits report",
+		reportLabel("Habits", report.ReportID, report.GeneratedAt))
+	doc.habitsSection(report)
+	return writePDF(w, fmt.Sprintf("habits-report-%d.pdf", report.ReportID), doc)
+}
+
+func renderTasksReportPDF(w http.ResponseWriter, report *tasks.TasksReport) error {
+	doc := newReportPDF("Tasks report",
+		reportLabel("Tasks", report.ReportID, report.GeneratedAt))
+	doc.tasksSection(report)
+	return writePDF(w, fmt.Sprintf("tasks-report-%d.pdf", report.ReportID), doc)
+}
+
+// renderSnapshotPDF puts a habits and a tasks report into one document
+func renderSnapshotPDF(w http.ResponseWriter, habitsReport *habits.HabitsReport,
+	tasksReport *tasks.TasksReport) error {
+	footer := reportLabel("Habits", habitsReport.ReportID, habitsReport.GeneratedAt) +
+		"; " + reportLabel("tasks", tasksReport.ReportID, tasksReport.GeneratedAt)
+	doc := newReportPDF("Habits and tasks summary", footer)
+	doc.habitsSection(habitsReport)
+	doc.AddPage()
+	doc.tasksSection(tasksReport)
+	return writePDF(w, fmt.Sprintf("summary-habits-%d-tasks-%d.pdf",
+		habitsReport.ReportID, tasksReport.ReportID), doc)
+}
+
+// writePDF renders the whole document before sending anything, so a
+// failure can still become an error response
+func writePDF(w http.ResponseWriter, filename string, doc *reportPDF) error {
+	var out bytes.Buffer
+	if err := doc.Output(&out); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": filename}))
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// hexColor parses #rrggbb
+func hexColor(hex string) (r, g, b int) {
+	fmt.Sscanf(hex, "#%02x%02x%02x", &r, &g, &b)
+	return r, g, b
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/registry"
+	"github/godspeedkil/admin-report/report"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// a report store the retention pruner applies to
+type pruneTarget struct {
+	kind	string
+	prune	func(context.Context, report.Retention, time.Time, bool) (report.PruneResult, error)
+}
+
+// pruneTargets must be called once the databases are open
+func pruneTargets() []pruneTarget {
+	targets := make([]pruneTarget, 0, len(registry.All))
+	for _, kind := range registry.All {
+		targets = append(targets, pruneTarget{kind.Name(), kind.Prune})
+	}
+	return targets
+}
+
+// runPruner applies each kind's retention policy every interval until ctx
+// is done, starting right away
+func runPruner(ctx context.Context, logger *slog.Logger, interval time.Duration,
+	dryRun bool, retention map[string]report.Retention, targets []pruneTarget) {
+	logger = logger.With("job", "pruner", "dryRun", dryRun)
+	logger.Info("retention pruner started", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pruneOnce(ctx, logger, dryRun, retention, targets)
+		select {
+		case <-ctx.Done():
+			logger.Info("retention pruner stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func pruneOnce(ctx context.Context, logger *slog.Logger, dryRun bool,
+	retention map[string]report.Retention, targets []pruneTarget) {
+	now := time.Now()
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+		result, err := target.prune(ctx, retention[target.kind], now, dryRun)
+		if err != nil {
+			logger.Error("could not prune reports", "kind", target.kind, "error", err)
+			continue
+		}
+		dryRunLabel := strconv.FormatBool(dryRun)
+		metrics.ReportsPruned.WithLabelValues(target.kind, "daily", dryRunLabel).Add(float64(result.Daily))
+		metrics.ReportsPruned.WithLabelValues(target.kind, "monthly", dryRunLabel).Add(float64(result.Monthly))
+		metrics.ReportsPruned.WithLabelValues(target.kind, "purged", dryRunLabel).Add(float64(result.Purged))
+		// archived inputs go with their reports
+		if inputArchive != nil && len(result.PurgedIDs) > 0 {
+			if err := inputArchive.Delete(ctx, target.kind, result.PurgedIDs); err != nil {
+				logger.Error("could not delete archived inputs", "kind", target.kind, "error", err)
+			}
+		}
+		if result.Daily + result.Monthly + result.Purged > 0 {
+			logger.Info("reports pruned", "kind", target.kind, "daily", result.Daily,
+				"monthly", result.Monthly, "purged", result.Purged)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github/godspeedkil/admin-report/auth"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/ratelimit"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// routeLimits are the token buckets guarding one route: one shared by all
+// callers and one per caller
+type routeLimits struct {
+	Global	ratelimit.Limit
+	Client	ratelimit.Limit
+}
+
+type rateLimiter struct {
+	route		string
+	limits		routeLimits
+	// per principal overrides of limits.Client
+	keyLimits	map[string]ratelimit.Limit
+	trustProxy	bool
+	global		*ratelimit.Limiter
+	clients		*ratelimit.Limiter
+}
+
+func newRateLimiter(route string, cfg config) *rateLimiter {
+	return &rateLimiter{
+		route:      route,
+		limits:     cfg.RateLimits[route],
+		keyLimits:  cfg.RateLimitKeys,
+		trustProxy: cfg.TrustProxyHeaders,
+		global:     ratelimit.NewLimiter(),
+		clients:    ratelimit.NewLimiter(),
+	}
+}
+
+// limit answers 429 with Retry-After once the caller or the route as a
+// whole has used up its tokens. Callers are identified by principal, which
+// must already be in the request context, or else by address.
+func (l *rateLimiter) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := "ip:" + clientIP(r, l.trustProxy)
+		clientLimit := l.limits.Client
+		if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+			client = "principal:" + principal.Name
+			if override, ok := l.keyLimits[principal.Name]; ok {
+				clientLimit = override
+			}
+		}
+
+		now := time.Now()
+		scope := "client"
+		allowed, retryAfter := l.clients.Allow(client, clientLimit, now)
+		if allowed {
+			scope = "global"
+			// a request the route as a whole turns away costs the caller
+			// nothing
+			if allowed, retryAfter = l.global.Allow("", l.limits.Global, now); !allowed {
+				l.clients.Refund(client)
+			}
+		}
+		if !allowed {
+			metrics.RateLimited.WithLabelValues(l.route, scope).Inc()
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeError(w, r, tooManyRequestsf(
+				fmt.Errorf("ratelimit: %s over %s limit on %s", client, scope, l.route),
+				"rate limit exceeded, retry in %d seconds", seconds))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// number of buckets above which refilled buckets are forgotten
+const SWEEP_THRESHOLD = 10000
+
+// Limit is a token bucket refilled at Rate tokens per second and holding at
+// most Burst tokens. The zero Limit is unlimited.
+type Limit struct {
+	Rate	float64
+	Burst	int
+}
+
+// ParseLimit reads "count/period", e.g. "5/1m" for five requests a minute
+// with bursts of up to five; "off" or "" means unlimited
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "off") {
+		return Limit{}, nil
+	}
+	countPart, periodPart, found := strings.Cut(s, "/")
+	if !found {
+		return Limit{}, fmt.Errorf("ratelimit: %q is not count/period", s)
+	}
+	count, err := strconv.Atoi(countPart)
+	if err != nil || count < 1 {
+		return Limit{}, fmt.Errorf("ratelimit: bad count in %q", s)
+	}
+	period, err := time.ParseDuration(periodPart)
+	if err != nil || period <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: bad period in %q", s)
+	}
+	return Limit{Rate: float64(count) / period.Seconds(), Burst: count}, nil
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, time.Duration(float64(l.Burst)/l.Rate*float64(time.Second)))
+}
+
+type bucket struct {
+	// limit the bucket was last used with, which keys may override
+	limit	Limit
+	tokens	float64
+	last	time.Time
+}
+
+// refill b up to now and try to take one token; otherwise report how long
+// until one is available
+func (b *bucket) take(limit Limit, now time.Time) (bool, time.Duration) {
+	b.limit = limit
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// time after which b has refilled completely
+func (b *bucket) full() time.Duration {
+	return time.Duration(float64(b.limit.Burst) / b.limit.Rate * float64(time.Second))
+}
+
+// Limiter keeps one token bucket per key
+type Limiter struct {
+	mu		sync.Mutex
+	buckets	map[string]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from key's bucket under limit. When it is empty,
+// Allow returns false and the time after which a retry can succeed.
+func (l *Limiter) Allow(key string, limit Limit, now time.Time) (bool, time.Duration) {
+	if !limit.Enabled() {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= SWEEP_THRESHOLD {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	return b.take(limit, now)
+}
+
+// Refund returns the token last taken from key's bucket, for a request
+// that was turned away by another limit after all
+func (l *Limiter) Refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens + 1)
+	}
+}
+
+// drop buckets that have refilled completely under their own limit, they
+// behave like new ones
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.full() {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package registry lists the report kinds once, with what every front end
+// needs to generate and store them; the server and reportctl both build
+// their kind tables from All.
+package registry
+
+import (
+	"context"
+	"errors"
+	"github/godspeedkil/admin-report/accounts"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/summary"
+	"github/godspeedkil/admin-report/tasks"
+	"log/slog"
+	"time"
+)
+
+var (
+	Habits = &Entry[habits.HabitsReport]{
+		ID:			"habits",
+		DB:			&habits.DB,
+		Open:		habits.Kind.Open,
+		Generate:	habits.GenerateHabitsReport,
+		Upstream:	habits.PingUpstream,
+		Sources:	[]string{"habits"},
+		Archived:	true,
+		CSVHeader:	habits.CSVHeader,
+		CSVRecord:	(*habits.HabitsReport).CSVRecord,
+	}
+	Tasks = &Entry[tasks.TasksReport]{
+		ID:			"tasks",
+		DB:			&tasks.DB,
+		Open:		tasks.Kind.Open,
+		Generate:	tasks.GenerateTasksReport,
+		Upstream:	tasks.PingUpstream,
+		Sources:	[]string{"tasks"},
+		Archived:	true,
+		CSVHeader:	tasks.CSVHeader,
+		CSVRecord:	(*tasks.TasksReport).CSVRecord,
+	}
+	Accounts = &Entry[accounts.AccountsReport]{
+		ID:			"accounts",
+		DB:			&accounts.DB,
+		Open:		accounts.Kind.Open,
+		Generate:	accounts.GenerateAccountsReport,
+		Upstream:	accounts.PingUpstream,
+		Sources:	[]string{"habits", "tasks"},
+		Archived:	true,
+		CSVHeader:	accounts.CSVHeader,
+		CSVRecord:	(*accounts.AccountsReport).CSVRecord,
+	}
+	// stores a habits and a tasks report with every summary, so its
+	// database is opened after theirs and closed before
+	Summary = &Entry[summary.SummaryReport]{
+		ID:			"summary",
+		DB:			&summary.DB,
+		Open:		summary.Open,
+		Generate:	summary.GenerateSummaryReport,
+		Discard:	summary.DiscardSubReports,
+		Sources:	[]string{"habits", "tasks"},
+		Stores:		[]string{"habits", "tasks"},
+	}
+)
+
+// All are the report kinds, in the order their databases are opened
+var All = []Kind{Habits, Tasks, Accounts, Summary}
+
+// Lookup returns the kind called name, nil if there is none
+func Lookup(name string) Kind {
+	for _, kind := range All {
+		if kind.Name() == name {
+			return kind
+		}
+	}
+	return nil
+}
+
+// Kind is a report kind whatever its report type. Reports are passed
+// around as pointers to that type, as returned by Generate, Get and List.
+type Kind interface {
+	Name() string
+	OpenDB(logger *slog.Logger) error
+	CloseDB() error
+	PingDB(ctx context.Context) error
+	// probe of the kind's own upstream, nil if it has none
+	PingUpstream() func(context.Context) error
+	// names of the record sources generation reads, see package sources
+	RecordSources() []string
+	// names of the other kinds generation stores reports of, whose
+	// databases must be open to generate
+	StoredKinds() []string
+	// whether the records reports are generated from are archived, when
+	// archiving is on
+	IsArchived() bool
+	Prune(ctx context.Context, retention report.Retention, now time.Time, dryRun bool) (report.PruneResult, error)
+	RefreshRollups(ctx context.Context, now time.Time) (int64, error)
+
+	GenerateReport(ctx context.Context) (any, error)
+	// SaveReport stores a generated report, discarding what generating it
+	// stored besides if that fails
+	SaveReport(ctx context.Context, rep any) (int64, error)
+	GetReport(ctx context.Context, reportId int64) (any, error)
+	ListReports(ctx context.Context, limit, offset int) ([]any, error)
+	// nil if the kind has no CSV form
+	CSVColumns() []string
+	CSVRow(rep any) []string
+}
+
+// Entry registers one report kind
+type Entry[R any] struct {
+	// name in routes, settings, metrics and logs
+	ID			string
+	// the package variable holding the kind's database, set by OpenDB
+	DB			*report.Database[R]
+	Open		func(report.MySQLConfig, *slog.Logger) (report.Database[R], error)
+	Generate	func(context.Context) (R, error)
+	// undoes what Generate stored besides the report, when the report
+	// cannot be saved; nil if Generate stores nothing
+	Discard		func(context.Context, *R) error
+	Upstream	func(context.Context) error
+	Sources		[]string
+	Stores		[]string
+	Archived	bool
+	// nil if the kind has no CSV form
+	CSVHeader	[]string
+	CSVRecord	func(*R) []string
+}
+
+func (e *Entry[R]) Name() string {
+	return e.ID
+}
+
+func (e *Entry[R]) OpenDB(logger *slog.Logger) error {
+	db, err := e.Open(report.DefaultMySQLConfig, logger)
+	if err != nil {
+		return err
+	}
+	*e.DB = db
+	return nil
+}
+
+func (e *Entry[R]) CloseDB() error {
+	return (*e.DB).Close()
+}
+
+func (e *Entry[R]) PingDB(ctx context.Context) error {
+	return (*e.DB).Ping(ctx)
+}
+
+func (e *Entry[R]) PingUpstream() func(context.Context) error {
+	return e.Upstream
+}
+
+func (e *Entry[R]) RecordSources() []string {
+	return e.Sources
+}
+
+func (e *Entry[R]) StoredKinds() []string {
+	return e.Stores
+}
+
+func (e *Entry[R]) IsArchived() bool {
+	return e.Archived
+}
+
+func (e *Entry[R]) Prune(ctx context.Context, retention report.Retention, now time.Time,
+	dryRun bool) (report.PruneResult, error) {
+	return (*e.DB).Prune(ctx, retention, now, dryRun)
+}
+
+func (e *Entry[R]) RefreshRollups(ctx context.Context, now time.Time) (int64, error) {
+	return (*e.DB).RefreshRollups(ctx, now)
+}
+
+func (e *Entry[R]) GenerateReport(ctx context.Context) (any, error) {
+	rep, err := e.Generate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &rep, nil
+}
+
+func (e *Entry[R]) SaveReport(ctx context.Context, rep any) (int64, error) {
+	return e.Save(ctx, rep.(*R))
+}
+
+// Save stores rep, discarding what generating it stored besides if that
+// fails
+func (e *Entry[R]) Save(ctx context.Context, rep *R) (int64, error) {
+	reportId, err := (*e.DB).Add(ctx, rep)
+	if err != nil && e.Discard != nil {
+		if discardErr := e.Discard(ctx, rep); discardErr != nil {
+			err = errors.Join(err, discardErr)
+		}
+	}
+	return reportId, err
+}
+
+func (e *Entry[R]) GetReport(ctx context.Context, reportId int64) (any, error) {
+	return (*e.DB).Get(ctx, reportId)
+}
+
+func (e *Entry[R]) ListReports(ctx context.Context, limit, offset int) ([]any, error) {
+	reports, err := (*e.DB).List(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	erased := make([]any, len(reports))
+	for i, rep := range reports {
+		erased[i] = rep
+	}
+	return erased, nil
+}
+
+func (e *Entry[R]) CSVColumns() []string {
+	return e.CSVHeader
+}
+
+func (e *Entry[R]) CSVRow(rep any) []string {
+	return e.CSVRecord(rep.(*R))
+}
@@ -0,0 +1,194 @@
+package report
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github/godspeedkil/admin-report/upstream"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInputNotArchived = errors.New("no input archived for this report")
+
+// ArchivedInput describes the upstream payloads a report was aggregated
+// from, kept as a gzip-compressed tar holding one file per payload, byte
+// for byte: each HTTP response body, each file read, and the rows of each
+// SQL query as NDJSON. Files are named after where they were read from and
+// sorted by name, so that identical inputs archive identically.
+type ArchivedInput struct {
+	Kind		string		`json:"kind"`
+	ReportID	int64		`json:"reportId"`
+	// hex sha256 of the uncompressed tar
+	SHA256		string		`json:"sha256"`
+	// records aggregated from the payloads
+	Records		int			`json:"records"`
+	// compressed size in bytes
+	Size		int64		`json:"size"`
+	ArchivedAt	time.Time	`json:"archivedAt"`
+}
+
+// Archive stores report inputs by kind and report id
+type Archive interface {
+	Put(ctx context.Context, input ArchivedInput, data io.Reader) error
+
+	// Get returns ErrInputNotArchived if nothing was archived for the report;
+	// the caller closes the reader
+	Get(ctx context.Context, kind string, reportId int64) (ArchivedInput, io.ReadCloser, error)
+
+	// Delete forgets the inputs of reportIds, and removes every stored input
+	// no report refers to any more
+	Delete(ctx context.Context, kind string, reportIds []int64) error
+
+	Close() error
+}
+
+// InputRecorder captures the upstream payloads as they are read, spooling
+// each to a temporary file so that memory use stays bounded, and counts the
+// records aggregated from them
+type InputRecorder struct {
+	mu			sync.Mutex
+	dir			string
+	payloads	[]recordedPayload
+	records		int
+}
+
+type recordedPayload struct {
+	name	string
+	source	string
+	path	string
+}
+
+var _ upstream.Capture = &InputRecorder{}
+
+// NewInputRecorder spools to a new directory in the temporary directory;
+// Close removes it
+func NewInputRecorder() (*InputRecorder, error) {
+	dir, err := os.MkdirTemp("", "report-input-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not spool report input: %v", err)
+	}
+	return &InputRecorder{dir: dir}, nil
+}
+
+// Payload spools one payload; the sources of a report may be read
+// concurrently
+func (r *InputRecorder) Payload(name, source string) (io.WriteCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path := filepath.Join(r.dir, strconv.Itoa(len(r.payloads)))
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not spool report input: %v", err)
+	}
+	r.payloads = append(r.payloads, recordedPayload{name, source, path})
+	return file, nil
+}
+
+func (r *InputRecorder) addRecord() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records++
+}
+
+// Finish archives the payloads and returns a reader over the compressed
+// archive, valid until Close; no payload may be added afterwards
+func (r *InputRecorder) Finish(kind string, reportId int64, now time.Time) (ArchivedInput, io.Reader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	file, err := os.Create(filepath.Join(r.dir, "input.tar.gz"))
+	if err != nil {
+		return ArchivedInput{}, nil, fmt.Errorf("could not spool report input: %v", err)
+	}
+	hash := sha256.New()
+	compressed := gzip.NewWriter(file)
+	archive := tar.NewWriter(io.MultiWriter(compressed, hash))
+	slices.SortStableFunc(r.payloads, func(a, b recordedPayload) int {
+		return strings.Compare(a.name, b.name)
+	})
+	for _, payload := range r.payloads {
+		if err := addToTar(archive, payload); err != nil {
+			return ArchivedInput{}, nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return ArchivedInput{}, nil, fmt.Errorf("could not archive payloads: %v", err)
+	}
+	if err := compressed.Close(); err != nil {
+		return ArchivedInput{}, nil, fmt.Errorf("could not compress payloads: %v", err)
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return ArchivedInput{}, nil, fmt.Errorf("could not spool report input: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return ArchivedInput{}, nil, fmt.Errorf("could not spool report input: %v", err)
+	}
+	return ArchivedInput{
+		Kind:		kind,
+		ReportID:	reportId,
+		SHA256:		hex.EncodeToString(hash.Sum(nil)),
+		Records:	r.records,
+		Size:		size,
+		ArchivedAt:	now.UTC(),
+	}, file, nil
+}
+
+// addToTar writes the spooled payload with fixed metadata, the source going
+// in a PAX comment
+func addToTar(archive *tar.Writer, payload recordedPayload) error {
+	file, err := os.Open(payload.path)
+	if err != nil {
+		return fmt.Errorf("could not read spooled payload: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("could not read spooled payload: %v", err)
+	}
+	if err := archive.WriteHeader(&tar.Header{
+		Name:		payload.name,
+		Mode:		0o644,
+		Size:		info.Size(),
+		ModTime:	time.Unix(0, 0),
+		Format:		tar.FormatPAX,
+		PAXRecords:	map[string]string{"comment": payload.source},
+	}); err != nil {
+		return fmt.Errorf("could not archive %s: %v", payload.name, err)
+	}
+	if _, err := io.Copy(archive, file); err != nil {
+		return fmt.Errorf("could not archive %s: %v", payload.name, err)
+	}
+	return nil
+}
+
+// Close removes the spooled payloads and archive
+func (r *InputRecorder) Close() error {
+	return os.RemoveAll(r.dir)
+}
+
+type contextKey int
+
+const recorderKey contextKey = iota
+
+// WithInputRecorder makes the upstream reads of Generate copy their
+// payloads to recorder, and Generate count the records it aggregates
+func WithInputRecorder(ctx context.Context, recorder *InputRecorder) context.Context {
+	return upstream.WithCapture(context.WithValue(ctx, recorderKey, recorder), recorder)
+}
+
+func recordInput(ctx context.Context) {
+	if recorder, ok := ctx.Value(recorderKey).(*InputRecorder); ok {
+		recorder.addRecord()
+	}
+}
@@ -0,0 +1,267 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github/godspeedkil/admin-report/metrics"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	inputArchivesTable = "input_archives"
+	inputBlobsTable = "input_blobs"
+	inputBlobHeadsTable = "input_blob_heads"
+	// blobs are stored in chunks of this many bytes, well below the default
+	// max_allowed_packet
+	ARCHIVE_CHUNK_SIZE = 1 << 20
+	// report ids per statement when deleting
+	archiveDeleteBatch = 500
+)
+
+// each distinct input is kept once in input_blobs, split in chunks; its
+// row in input_blob_heads is locked by whoever writes or collects the chunks
+var createArchiveTableStatements = []string{
+	fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s DEFAULT CHARACTER SET = 'utf8' DEFAULT COLLATE 'utf8_general_ci';", DATABASE_NAME),
+	fmt.Sprintf("USE %s;", DATABASE_NAME),
+	`CREATE TABLE IF NOT EXISTS input_blob_heads (
+		sha256 CHAR(64) NOT NULL,
+		PRIMARY KEY (sha256)
+	);`,
+	`CREATE TABLE IF NOT EXISTS input_blobs (
+		sha256 CHAR(64) NOT NULL,
+		seq INT UNSIGNED NOT NULL,
+		data MEDIUMBLOB NOT NULL,
+		PRIMARY KEY (sha256, seq)
+	);`,
+	`CREATE TABLE IF NOT EXISTS input_archives (
+		kind VARCHAR(32) NOT NULL,
+		report_id INT UNSIGNED NOT NULL,
+		sha256 CHAR(64) NOT NULL,
+		records INT UNSIGNED NOT NULL,
+		size BIGINT UNSIGNED NOT NULL,
+		archived_at DATETIME(3) NOT NULL,
+		PRIMARY KEY (kind, report_id),
+		INDEX input_archives_sha256 (sha256)
+	);`,
+}
+
+type mysqlArchive struct {
+	conn	*sql.DB
+}
+
+var _ Archive = &mysqlArchive{}
+
+// NewMySQLArchive archives inputs in the reports database, creating its
+// tables if needed
+func NewMySQLArchive(config MySQLConfig, logger *slog.Logger) (Archive, error) {
+	logger = logger.With("component", inputArchivesTable)
+	// input_blob_heads is the newest table, the statements create whichever
+	// is missing
+	if err := config.EnsureTableExists(inputBlobHeadsTable, createArchiveTableStatements,
+		nil, logger); err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("mysql", config.DataStoreName(DATABASE_NAME))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	return &mysqlArchive{conn: conn}, nil
+}
+
+func (a *mysqlArchive) Close() error {
+	return a.conn.Close()
+}
+
+// Put stores the blob chunk by chunk unless an identical one is stored
+// already, then links the report to it. Puts and Deletes of the same blob
+// are serialized by its input_blob_heads row.
+func (a *mysqlArchive) Put(ctx context.Context, input ArchivedInput, data io.Reader) (err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(inputArchivesTable, "insert", start, err)
+	}()
+
+	tx, err := a.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("mysql: could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// an existing row is locked like a new one, unlike a locking read of
+	// absent chunks, which only takes a gap lock that two Puts can share
+	if _, err = tx.ExecContext(ctx, "INSERT INTO input_blob_heads(sha256) VALUES (?) ON DUPLICATE KEY UPDATE sha256 = sha256;",
+		input.SHA256); err != nil {
+		return fmt.Errorf("mysql: could not insert into %s: %v", inputBlobHeadsTable, err)
+	}
+	var chunks int
+	if err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM input_blobs WHERE sha256 = ?;",
+		input.SHA256).Scan(&chunks); err != nil {
+		return fmt.Errorf("mysql: could not read %s: %v", inputBlobsTable, err)
+	}
+	if chunks == 0 {
+		chunk := make([]byte, ARCHIVE_CHUNK_SIZE)
+		for seq := 0; ; seq++ {
+			n, readErr := io.ReadFull(data, chunk)
+			if n > 0 {
+				if _, err = tx.ExecContext(ctx, "INSERT INTO input_blobs(sha256, seq, data) VALUES (?, ?, ?);",
+					input.SHA256, seq, chunk[:n]); err != nil {
+					return fmt.Errorf("mysql: could not insert into %s: %v", inputBlobsTable, err)
+				}
+			}
+			if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+				break
+			}
+			if readErr != nil {
+				err = readErr
+				return fmt.Errorf("archive: could not read input: %v", err)
+			}
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `REPLACE INTO input_archives(kind, report_id, sha256, records, size, archived_at)
+		VALUES (?, ?, ?, ?, ?, ?);`, input.Kind, input.ReportID, input.SHA256,
+		input.Records, input.Size, input.ArchivedAt); err != nil {
+		return fmt.Errorf("mysql: could not insert into %s: %v", inputArchivesTable, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("mysql: could not commit: %v", err)
+	}
+	return nil
+}
+
+// Get streams the blob's chunks in order as they are read
+func (a *mysqlArchive) Get(ctx context.Context, kind string, reportId int64) (input ArchivedInput, data io.ReadCloser, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(inputArchivesTable, "get", start, err)
+	}()
+
+	err = a.conn.QueryRowContext(ctx, `
+		SELECT kind, report_id, sha256, records, size, archived_at
+		FROM input_archives WHERE kind = ? AND report_id = ?;`, kind, reportId).Scan(&input.Kind,
+		&input.ReportID, &input.SHA256, &input.Records, &input.Size, &input.ArchivedAt)
+	if err == sql.ErrNoRows {
+		return input, nil, ErrInputNotArchived
+	}
+	if err != nil {
+		return input, nil, fmt.Errorf("mysql: could not get archived input: %v", err)
+	}
+
+	rows, err := a.conn.QueryContext(ctx, "SELECT data FROM input_blobs WHERE sha256 = ? ORDER BY seq;",
+		input.SHA256)
+	if err != nil {
+		return input, nil, fmt.Errorf("mysql: could not get archived input: %v", err)
+	}
+	return input, &chunkReader{rows: rows}, nil
+}
+
+// chunkReader reads the data column of rows one row after the other
+type chunkReader struct {
+	rows	*sql.Rows
+	chunk	[]byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if !r.rows.Next() {
+			if err := r.rows.Err(); err != nil {
+				return 0, fmt.Errorf("mysql: could not read archived input: %v", err)
+			}
+			return 0, io.EOF
+		}
+		if err := r.rows.Scan(&r.chunk); err != nil {
+			return 0, fmt.Errorf("mysql: could not read archived input: %v", err)
+		}
+	}
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	return r.rows.Close()
+}
+
+// Delete unlinks the reports in batches, then removes the blobs they were
+// the last to refer to
+func (a *mysqlArchive) Delete(ctx context.Context, kind string, reportIds []int64) (err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(inputArchivesTable, "delete", start, err)
+	}()
+
+	for len(reportIds) > 0 {
+		batch := reportIds[:min(len(reportIds), archiveDeleteBatch)]
+		reportIds = reportIds[len(batch):]
+		if err = a.deleteBatch(ctx, kind, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *mysqlArchive) deleteBatch(ctx context.Context, kind string, reportIds []int64) error {
+	tx, err := a.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("mysql: could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{kind}
+	for _, reportId := range reportIds {
+		args = append(args, reportId)
+	}
+	where := fmt.Sprintf("kind = ? AND report_id IN (%s)",
+		strings.TrimSuffix(strings.Repeat("?, ", len(reportIds)), ", "))
+
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT sha256 FROM input_archives WHERE " + where, args...)
+	if err != nil {
+		return fmt.Errorf("mysql: could not read %s: %v", inputArchivesTable, err)
+	}
+	var shas []interface{}
+	for rows.Next() {
+		var sha string
+		if err := rows.Scan(&sha); err != nil {
+			rows.Close()
+			return fmt.Errorf("mysql: could not read %s: %v", inputArchivesTable, err)
+		}
+		shas = append(shas, sha)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("mysql: could not read %s: %v", inputArchivesTable, err)
+	}
+	if len(shas) == 0 {
+		return nil
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(shas)), ", ")
+
+	// the heads are locked first, as Put does, so that a Put linking one of
+	// the blobs either commits before it is collected or stores it again
+	heads, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT sha256 FROM input_blob_heads WHERE sha256 IN (%s) ORDER BY sha256 FOR UPDATE;",
+		in), shas...)
+	if err != nil {
+		return fmt.Errorf("mysql: could not lock %s: %v", inputBlobHeadsTable, err)
+	}
+	heads.Close()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM input_archives WHERE " + where, args...); err != nil {
+		return fmt.Errorf("mysql: could not delete from %s: %v", inputArchivesTable, err)
+	}
+	for _, table := range []string{inputBlobsTable, inputBlobHeadsTable} {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s
+			WHERE sha256 IN (%s) AND NOT EXISTS (
+				SELECT 1 FROM input_archives WHERE input_archives.sha256 = %s.sha256
+			);`, table, in, table), shas...); err != nil {
+			return fmt.Errorf("mysql: could not delete from %s: %v", table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("mysql: could not commit: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// dirArchive keeps each distinct input once, as blobs/<sha256>.tar.gz,
+// and links reports to it through <kind>/<reportId>.json
+type dirArchive struct {
+	dir	string
+	// a blob must not be collected between Put finding it and linking it
+	mu	sync.Mutex
+}
+
+var _ Archive = &dirArchive{}
+
+// NewDirArchive archives inputs below dir, creating it if needed
+func NewDirArchive(dir string) (Archive, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blobs"), 0o755); err != nil {
+		return nil, fmt.Errorf("archive: could not create %s: %v", dir, err)
+	}
+	return &dirArchive{dir: dir}, nil
+}
+
+func (a *dirArchive) blobPath(sha string) string {
+	return filepath.Join(a.dir, "blobs", sha + ".tar.gz")
+}
+
+func (a *dirArchive) linkPath(kind string, reportId int64) string {
+	return filepath.Join(a.dir, kind, fmt.Sprintf("%d.json", reportId))
+}
+
+func (a *dirArchive) Put(ctx context.Context, input ArchivedInput, data io.Reader) error {
+	link, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("archive: could not encode %s report %d: %v", input.Kind, input.ReportID, err)
+	}
+	if err := os.MkdirAll(filepath.Join(a.dir, input.Kind), 0o755); err != nil {
+		return fmt.Errorf("archive: could not create %s: %v", input.Kind, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	blob := a.blobPath(input.SHA256)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := writeFileAtomic(blob, data); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(a.linkPath(input.Kind, input.ReportID), bytes.NewReader(link))
+}
+
+func (a *dirArchive) Get(ctx context.Context, kind string, reportId int64) (ArchivedInput, io.ReadCloser, error) {
+	var input ArchivedInput
+	link, err := os.ReadFile(a.linkPath(kind, reportId))
+	if os.IsNotExist(err) {
+		return input, nil, ErrInputNotArchived
+	}
+	if err != nil {
+		return input, nil, fmt.Errorf("archive: %v", err)
+	}
+	if err := json.Unmarshal(link, &input); err != nil {
+		return input, nil, fmt.Errorf("archive: could not decode %s report %d: %v", kind, reportId, err)
+	}
+
+	blob, err := os.Open(a.blobPath(input.SHA256))
+	if err != nil {
+		return input, nil, fmt.Errorf("archive: %v", err)
+	}
+	return input, blob, nil
+}
+
+// Delete removes the links, then every blob no remaining link refers to
+func (a *dirArchive) Delete(ctx context.Context, kind string, reportIds []int64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, reportId := range reportIds {
+		if err := os.Remove(a.linkPath(kind, reportId)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("archive: %v", err)
+		}
+	}
+
+	referenced := make(map[string]bool)
+	links, err := filepath.Glob(filepath.Join(a.dir, "*", "*.json"))
+	if err != nil {
+		return fmt.Errorf("archive: %v", err)
+	}
+	for _, path := range links {
+		link, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("archive: %v", err)
+		}
+		var input ArchivedInput
+		if err := json.Unmarshal(link, &input); err != nil {
+			return fmt.Errorf("archive: could not decode %s: %v", path, err)
+		}
+		referenced[input.SHA256] = true
+	}
+
+	blobs, err := filepath.Glob(filepath.Join(a.dir, "blobs", "*.tar.gz"))
+	if err != nil {
+		return fmt.Errorf("archive: %v", err)
+	}
+	for _, path := range blobs {
+		if !referenced[strings.TrimSuffix(filepath.Base(path), ".tar.gz")] {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("archive: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *dirArchive) Close() error {
+	return nil
+}
+
+// readers never see a partially written file
+func writeFileAtomic(path string, data io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("archive: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("archive: could not write %s: %v", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("archive: could not write %s: %v", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("archive: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package report
+
+import "strings"
+
+// leading characters spreadsheets read as the start of a formula
+const CSV_FORMULA_PREFIXES = "=+-@\t\r"
+
+// CSVEscape prefixes cells a spreadsheet would evaluate with ', so that
+// an upstream title such as =HYPERLINK(...) is shown rather than run
+func CSVEscape(cell string) string {
+	if cell != "" && strings.ContainsRune(CSV_FORMULA_PREFIXES, rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// CSVEscapeRecord applies CSVEscape to every cell of record in place
+func CSVEscapeRecord(record []string) []string {
+	for i := range record {
+		record[i] = CSVEscape(record[i])
+	}
+	return record
+}
@@ -0,0 +1,62 @@
+package report
+
+import (
+	"fmt"
+	"sort"
+)
+
+// how many record IDs are kept as samples per rejection reason
+const MAX_SAMPLE_IDS = 5
+
+// DataQuality counts the upstream records a report was built from, and why
+// the invalid ones were left out of its metrics
+type DataQuality struct {
+	Valid		int					`json:"valid"`
+	Invalid		int					`json:"invalid"`
+	// reason -> records rejected for it; a record can have several reasons
+	Rejected	map[string]int		`json:"rejected"`
+	// reason -> IDs of the first records rejected for it
+	Samples		map[string][]string	`json:"samples"`
+}
+
+func NewDataQuality() DataQuality {
+	return DataQuality{
+		Rejected:	make(map[string]int),
+		Samples:	make(map[string][]string),
+	}
+}
+
+// Record classifies one record from its rejection reasons, valid if there
+// are none, and reports whether it is valid. Records without an ID are
+// sampled by their position in the stream, starting at 1.
+func (q *DataQuality) Record(id string, reasons []string) bool {
+	if len(reasons) == 0 {
+		q.Valid++
+		return true
+	}
+	if q.Rejected == nil {
+		q.Rejected = make(map[string]int)
+		q.Samples = make(map[string][]string)
+	}
+	if id == "" {
+		id = fmt.Sprintf("#%d", q.Valid + q.Invalid + 1)
+	}
+	q.Invalid++
+	for _, reason := range reasons {
+		q.Rejected[reason]++
+		if len(q.Samples[reason]) < MAX_SAMPLE_IDS {
+			q.Samples[reason] = append(q.Samples[reason], id)
+		}
+	}
+	return false
+}
+
+// Reasons returns the rejection reasons in alphabetical order
+func (q DataQuality) Reasons() []string {
+	reasons := make([]string, 0, len(q.Rejected))
+	for reason := range q.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
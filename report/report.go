@@ -0,0 +1,85 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"github/godspeedkil/admin-report/metrics"
+	"log/slog"
+	"time"
+)
+
+// returned by Database.Get when no row matches the requested id
+var ErrReportNotFound = errors.New("mysql: report not found")
+
+// Database stores the reports of one kind
+type Database[R any] interface {
+	Add(context.Context, *R) (reportId int64, err error)
+
+	Get(ctx context.Context, reportId int64)	(*R, error)
+
+	// List returns up to limit reports, newest first, skipping the first
+	// offset
+	List(ctx context.Context, limit, offset int) ([]*R, error)
+
+	// Delete soft-deletes a report: Get and List no longer return it
+	Delete(ctx context.Context, reportId int64) error
+
+	// Prune applies retention as of now; with dryRun it only counts
+	Prune(ctx context.Context, retention Retention, now time.Time, dryRun bool) (PruneResult, error)
+
+	// RefreshRollups brings the rollups up to date as of now
+	RefreshRollups(ctx context.Context, now time.Time) (refreshed int64, err error)
+
+	ListRollups(ctx context.Context, filter RollupFilter) ([]Rollup, error)
+
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// Aggregator reduces a stream of records to a report, one record at a time
+type Aggregator[T, R any] interface {
+	Add(record T)
+
+	Report() R
+}
+
+// Kind is everything needed to support one type of report: a fetcher
+// streaming the upstream records of type T, an aggregator reducing them to
+// a report R, and the schema R is stored with
+type Kind[T, R any] struct {
+	// label used in metrics and logs, e.g. "habits"
+	Name			string
+	// Fetch passes every upstream record to yield, stopping at the first
+	// error yield returns
+	Fetch			func(ctx context.Context, yield func(T) error) error
+	NewAggregator	func() Aggregator[T, R]
+	Schema			Schema[R]
+}
+
+// Generate streams every upstream record through a new aggregator; with an
+// InputRecorder in ctx, the payloads read are archived
+func (k *Kind[T, R]) Generate(ctx context.Context) (report R, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveReportGeneration(k.Name, start, err)
+	}()
+
+	aggregator := k.NewAggregator()
+	err = k.Fetch(ctx, func(record T) error {
+		aggregator.Add(record)
+		recordInput(ctx)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return aggregator.Report(), nil
+}
+
+// Open connects to the table holding this kind's reports, creating it if
+// needed
+func (k *Kind[T, R]) Open(config MySQLConfig, logger *slog.Logger) (Database[R], error) {
+	return NewMySQLDB(config, k.Schema, logger)
+}
@@ -0,0 +1,392 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"database/sql/driver"
+	"github.com/go-sql-driver/mysql"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const DATABASE_NAME = "arqui"
+const dbDoesNotExistError = 1049
+const tableDoesNotExistError = 1146
+
+var errNoRowsAffected = errors.New("mysql: expected 1 row affected, got 0")
+
+// Column is one stored field of a report, besides the report_id key
+type Column struct {
+	Name, Definition	string
+	// set for columns added after the table was first released; tables
+	// created by older versions get them on startup
+	Added				bool
+}
+
+// Schema maps a report onto a table. Values and Scan must follow the order
+// of Columns; Scan additionally reads report_id first.
+type Schema[R any] struct {
+	Table	string
+	Columns	[]Column
+	Values	func(*R) []interface{}
+	Scan	func(RowScanner) (*R, error)
+	// integer columns summarized by the rollups
+	Metrics	[]string
+}
+
+// every table has it, outside Schema.Columns; set when a report is
+// deleted, such reports are no longer returned
+var deletedAtColumn = Column{Name: "deleted_at", Definition: "DATETIME(3)", Added: true}
+
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s Schema[R]) columnNames() string {
+	names := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		names[i] = column.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s Schema[R]) insertStatement() string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(s.Columns)), ", ")
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s);", s.Table, s.columnNames(), placeholders)
+}
+
+func (s Schema[R]) selectColumns() string {
+	return fmt.Sprintf("SELECT report_id, %s FROM %s", s.columnNames(), s.Table)
+}
+
+func (s Schema[R]) getStatement() string {
+	return s.selectColumns() + " WHERE report_id = ? AND deleted_at IS NULL;"
+}
+
+func (s Schema[R]) listStatement() string {
+	return s.selectColumns() + " WHERE deleted_at IS NULL ORDER BY report_id DESC LIMIT ? OFFSET ?;"
+}
+
+func (s Schema[R]) deleteStatement() string {
+	return fmt.Sprintf("UPDATE %s SET deleted_at = ? WHERE report_id = ? AND deleted_at IS NULL;", s.Table)
+}
+
+// every column, deleted_at included
+func (s Schema[R]) allColumns() []Column {
+	return append(append([]Column{}, s.Columns...), deletedAtColumn)
+}
+
+func (s Schema[R]) createTableStatements() []string {
+	definitions := []string{"report_id INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY"}
+	for _, column := range s.allColumns() {
+		definitions = append(definitions, column.Name + " " + column.Definition)
+	}
+	return []string{
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s DEFAULT CHARACTER SET = 'utf8' DEFAULT COLLATE 'utf8_general_ci';", DATABASE_NAME),
+		fmt.Sprintf("USE %s;", DATABASE_NAME),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n);", s.Table, strings.Join(definitions, ",\n\t")),
+	}
+}
+
+type mysqlDB[R any] struct {
+	conn	*sql.DB
+	schema	Schema[R]
+
+	insert 		*sql.Stmt
+	get			*sql.Stmt
+	list		*sql.Stmt
+	delete		*sql.Stmt
+}
+
+type MySQLConfig struct {
+	Username, Password 	string
+	Host 				string
+	Port 				int
+}
+
+// DefaultMySQLConfig is the server every report, audit and archive table
+// lives on
+var DefaultMySQLConfig = MySQLConfig{
+	Username:	"root",
+	Password:	"admin",
+	Host:		"localhost",
+	Port:		3306,
+}
+
+// DataStoreName returns the connection string for sql.Open; DATETIME
+// columns scan into time.Time in UTC
+func (c MySQLConfig) DataStoreName(dbName string) string {
+	var credentials string
+	if c.Username != "" {
+		credentials = c.Username
+		if c.Password != "" {
+			credentials = credentials + ":" + c.Password
+		}
+		credentials = credentials + "@"
+	}
+	return fmt.Sprintf("%stcp([%s]:%d)/%s?parseTime=true&loc=UTC", credentials, c.Host, c.Port, dbName)
+}
+
+// NewMySQLDB connects to the table described by schema, creating it or
+// adding missing columns as needed
+func NewMySQLDB[R any](config MySQLConfig, schema Schema[R], logger *slog.Logger) (Database[R], error) {
+	logger = logger.With("component", schema.Table)
+	if err := config.EnsureTableExists(schema.Table, schema.createTableStatements(),
+		schema.allColumns(), logger); err != nil {
+		return nil, err
+	}
+
+	conn, err := sql.Open("mysql", config.DataStoreName(DATABASE_NAME))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+
+	db := &mysqlDB[R] {
+		conn: conn,
+		schema: schema,
+	}
+
+	if _, err := conn.Exec(schema.createRollupTableStatement()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: could not create %s: %v", schema.rollupTable(), err)
+	}
+	if err := addMissingColumns(conn, schema.rollupTable(), []Column{rollupFinalColumn}, logger); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if db.get, err = conn.Prepare(schema.getStatement()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: prepare get: %v", err)
+	}
+	if db.insert, err = conn.Prepare(schema.insertStatement()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: prepare insert: %v", err)
+	}
+	if db.list, err = conn.Prepare(schema.listStatement()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: prepare list: %v", err)
+	}
+	if db.delete, err = conn.Prepare(schema.deleteStatement()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: prepare delete: %v", err)
+	}
+
+	logger.Info("connected to mysql", "host", config.Host, "port", config.Port)
+	return db, nil
+}
+
+// Close releases the prepared statements and the connection pool
+func (db *mysqlDB[R]) Close() error {
+	var errs []error
+	for _, stmt := range []*sql.Stmt{db.get, db.insert, db.list, db.delete} {
+		if stmt != nil {
+			errs = append(errs, stmt.Close())
+		}
+	}
+	errs = append(errs, db.conn.Close())
+	return errors.Join(errs...)
+}
+
+// Ping verifies a connection to the database can be established
+func (db *mysqlDB[R]) Ping(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+// EnsureTableExists runs createStatements if the database or table doesn't
+// exist yet, and otherwise adds the Added columns it lacks
+func (config MySQLConfig) EnsureTableExists(table string, createStatements []string,
+	columns []Column, logger *slog.Logger) error {
+	conn, err := sql.Open("mysql", config.DataStoreName(""))
+	if err != nil {
+		return fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	defer conn.Close()
+
+	if conn.Ping() == driver.ErrBadConn {
+		return fmt.Errorf("mysql: could not connect to db. ")
+	}
+
+	if _, err := conn.Exec(`USE ` + DATABASE_NAME); err != nil {
+		if mErr, ok := err.(*mysql.MySQLError); ok && mErr.Number == dbDoesNotExistError {
+			logger.Info("database does not exist, creating it")
+			return createTable(conn, createStatements)
+		}
+	}
+
+	if _, err := conn.Exec(`DESCRIBE ` + table); err != nil {
+		if mErr, ok := err.(*mysql.MySQLError); ok && mErr.Number == tableDoesNotExistError {
+			logger.Info("table does not exist, creating it", "table", table)
+			return createTable(conn, createStatements)
+		}
+		return fmt.Errorf("mysql: could not connect to db: %v", err)
+	}
+	return addMissingColumns(conn, table, columns, logger)
+}
+
+// bring a table created by an older version up to date
+func addMissingColumns(conn *sql.DB, table string, columns []Column, logger *slog.Logger) error {
+	for _, column := range columns {
+		if !column.Added {
+			continue
+		}
+		var count int
+		err := conn.QueryRow(`
+			SELECT COUNT(*)
+			FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+				AND COLUMN_NAME = ?`, DATABASE_NAME, table, column.Name).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("mysql: could not inspect %s: %v", table, err)
+		}
+		if count > 0 {
+			continue
+		}
+		logger.Info("adding missing column", "table", table, "column", column.Name)
+		if _, err := conn.Exec(fmt.Sprintf("ALTER TABLE %s.%s ADD COLUMN %s %s",
+			DATABASE_NAME, table, column.Name, column.Definition)); err != nil {
+			return fmt.Errorf("mysql: could not add column %s: %v", column.Name, err)
+		}
+	}
+	return nil
+}
+
+// create db and table, as necessary
+func createTable(conn *sql.DB, createStatements []string) error {
+	for _, stmt := range createStatements {
+		_, err := conn.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute a statement, expecting one row affected
+func execAffectingOneRow(ctx context.Context, stmt *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return result, fmt.Errorf("mysql: could not execute statement: %v", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return result, fmt.Errorf("mysql: could not get rows affected: %v", err)
+	} else if rowsAffected == 0 {
+		return result, errNoRowsAffected
+	} else if rowsAffected != 1 {
+		return result, fmt.Errorf("mysql: expected 1 row affected, got %d", rowsAffected)
+	}
+	return result, nil
+}
+
+func (db *mysqlDB[R]) Get(ctx context.Context, reportId int64) (*R, error) {
+	table := db.schema.Table
+	start := time.Now()
+	report, err := db.schema.Scan(db.get.QueryRowContext(ctx, reportId))
+	if err == sql.ErrNoRows {
+		metrics.ObserveDBQuery(table, "get", start, nil)
+		logging.FromContext(ctx).Debug("report not found", "table", table, "reportID", reportId)
+		return nil, ErrReportNotFound
+	}
+	metrics.ObserveDBQuery(table, "get", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get report from %s: %v", table, err)
+	}
+	return report, nil
+}
+
+func (db *mysqlDB[R]) Add(ctx context.Context, report *R) (reportId int64, err error) {
+	table := db.schema.Table
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(table, "add", start, err)
+	}()
+
+	result, err := execAffectingOneRow(ctx, db.insert, db.schema.Values(report)...)
+	if err != nil {
+		return 0, err
+	}
+
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not get last insert ID: %v", err)
+	}
+
+	logging.FromContext(ctx).Debug("report stored", "table", table, "reportID", lastInsertID)
+	return lastInsertID, nil
+}
+
+func (db *mysqlDB[R]) List(ctx context.Context, limit, offset int) (reports []*R, err error) {
+	table := db.schema.Table
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(table, "list", start, err)
+	}()
+
+	rows, err := db.list.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not list %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	reports = make([]*R, 0)
+	for rows.Next() {
+		report, err := db.schema.Scan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("mysql: could not read report from %s: %v", table, err)
+		}
+		reports = append(reports, report)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("mysql: could not list %s: %v", table, err)
+	}
+	return reports, nil
+}
+
+// Delete marks a report deleted; it is kept in the table until purged
+func (db *mysqlDB[R]) Delete(ctx context.Context, reportId int64) (err error) {
+	table := db.schema.Table
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(table, "delete", start, err)
+	}()
+
+	_, err = execAffectingOneRow(ctx, db.delete, time.Now().UTC(), reportId)
+	if err == errNoRowsAffected {
+		return ErrReportNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Debug("report deleted", "table", table, "reportID", reportId)
+	return nil
+}
+
+// JSONColumn encodes v for a TEXT column holding a JSON document, NULL if v
+// is nil. v must be of a type that always encodes.
+func JSONColumn[T any](v *T) sql.NullString {
+	if v == nil {
+		return sql.NullString{}
+	}
+	document, _ := json.Marshal(v)
+	return sql.NullString{String: string(document), Valid: true}
+}
+
+// ScanJSONColumn decodes a column written by JSONColumn, nil for NULL
+func ScanJSONColumn[T any](column sql.NullString) (*T, error) {
+	if !column.Valid || column.String == "" {
+		return nil, nil
+	}
+	v := new(T)
+	if err := json.Unmarshal([]byte(column.String), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
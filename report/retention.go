@@ -0,0 +1,152 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"time"
+)
+
+// Retention decides which stored reports Prune removes. The first report
+// generated in each calendar month (UTC) is that month's monthly report;
+// every other report is a daily. A zero duration keeps reports forever.
+// Reports stored before generation times were recorded are always kept.
+type Retention struct {
+	// soft-delete dailies older than this
+	Daily	time.Duration
+	// soft-delete monthlies older than this
+	Monthly	time.Duration
+	// delete soft-deleted reports for good once deleted this long
+	Purge	time.Duration
+}
+
+// PruneResult counts the reports a Prune removed, or would remove
+type PruneResult struct {
+	Daily		int64
+	Monthly		int64
+	Purged		int64
+	// ids of the purged reports, for whatever else is kept per report;
+	// empty in a dry run
+	PurgedIDs	[]int64
+}
+
+// ids of the monthly reports, as a derived table so that MySQL accepts it
+// in an UPDATE of the same table
+func (s Schema[R]) monthliesQuery() string {
+	return fmt.Sprintf(`SELECT report_id FROM (
+		SELECT MIN(report_id) AS report_id FROM %s
+		WHERE deleted_at IS NULL AND created_at IS NOT NULL
+		GROUP BY DATE_FORMAT(created_at, '%%Y-%%m')
+	) AS monthlies`, s.Table)
+}
+
+// Prune soft-deletes the dailies, then the monthlies, that have outlived
+// retention, then purges reports soft-deleted long enough ago. The rollups
+// are brought up to date first, so that a day's rollup is final before any
+// of its reports is removed.
+func (db *mysqlDB[R]) Prune(ctx context.Context, retention Retention, now time.Time,
+	dryRun bool) (result PruneResult, err error) {
+	table := db.schema.Table
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(table, "prune", start, err)
+	}()
+
+	now = now.UTC()
+	// roll up every closed day before its reports can go
+	if !dryRun {
+		if _, err = db.RefreshRollups(ctx, now); err != nil {
+			return result, err
+		}
+	}
+	if retention.Daily > 0 {
+		result.Daily, err = db.pruneWhere(ctx, dryRun, now, fmt.Sprintf(
+			"deleted_at IS NULL AND created_at < ? AND report_id NOT IN (%s)",
+			db.schema.monthliesQuery()), now.Add(-retention.Daily))
+		if err != nil {
+			return result, err
+		}
+	}
+	if retention.Monthly > 0 {
+		result.Monthly, err = db.pruneWhere(ctx, dryRun, now, fmt.Sprintf(
+			"deleted_at IS NULL AND created_at < ? AND report_id IN (%s)",
+			db.schema.monthliesQuery()), now.Add(-retention.Monthly))
+		if err != nil {
+			return result, err
+		}
+	}
+	if retention.Purge > 0 {
+		cutoff := now.Add(-retention.Purge)
+		if dryRun {
+			err = db.conn.QueryRowContext(ctx, fmt.Sprintf(
+				"SELECT COUNT(*) FROM %s WHERE deleted_at < ?", table), cutoff).Scan(&result.Purged)
+		} else {
+			result.PurgedIDs, result.Purged, err = db.purge(ctx, cutoff)
+		}
+		if err != nil {
+			return result, fmt.Errorf("mysql: could not purge %s: %v", table, err)
+		}
+	}
+
+	logging.FromContext(ctx).Debug("reports pruned", "table", table, "dryRun", dryRun,
+		"daily", result.Daily, "monthly", result.Monthly, "purged", result.Purged)
+	return result, nil
+}
+
+// soft-delete the rows matching where, or only count them with dryRun
+func (db *mysqlDB[R]) pruneWhere(ctx context.Context, dryRun bool, now time.Time,
+	where string, args ...interface{}) (int64, error) {
+	table := db.schema.Table
+	if dryRun {
+		var count int64
+		err := db.conn.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s",
+			table, where), args...).Scan(&count)
+		if err != nil {
+			return 0, fmt.Errorf("mysql: could not count prunable %s: %v", table, err)
+		}
+		return count, nil
+	}
+
+	result, err := db.conn.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET deleted_at = ? WHERE %s",
+		table, where), append([]interface{}{now}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not prune %s: %v", table, err)
+	}
+	return result.RowsAffected()
+}
+
+// delete the reports soft-deleted before cutoff for good; reports deleted
+// from now on have a later deleted_at, so the ids read first are exactly
+// those deleted
+func (db *mysqlDB[R]) purge(ctx context.Context, cutoff time.Time) (ids []int64, purged int64, err error) {
+	table := db.schema.Table
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf(
+		"SELECT report_id FROM %s WHERE deleted_at < ?", table), cutoff)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(ids) == 0 {
+		return nil, 0, nil
+	}
+
+	var res sql.Result
+	if res, err = db.conn.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE deleted_at < ?", table), cutoff); err != nil {
+		return nil, 0, err
+	}
+	purged, err = res.RowsAffected()
+	return ids, purged, err
+}
@@ -0,0 +1,240 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github/godspeedkil/admin-report/metrics"
+	"strings"
+	"time"
+)
+
+// rollup periods; weeks start on Monday, every period in UTC
+const (
+	PERIOD_DAY = "day"
+	PERIOD_WEEK = "week"
+	PERIOD_MONTH = "month"
+)
+
+var Periods = []string{PERIOD_DAY, PERIOD_WEEK, PERIOD_MONTH}
+
+// SQL expression for the start of the week or month containing the start
+// of a daily rollup
+var periodStartExpressions = map[string]string{
+	PERIOD_WEEK:	"DATE_SUB(period_start, INTERVAL WEEKDAY(period_start) DAY)",
+	PERIOD_MONTH:	"DATE_SUB(period_start, INTERVAL DAYOFMONTH(period_start) - 1 DAY)",
+}
+
+// set once a rollup's period has closed and it has been computed since;
+// added to tables created before it existed
+var rollupFinalColumn = Column{Name: "final", Definition: "BOOLEAN NOT NULL DEFAULT FALSE", Added: true}
+
+// PeriodStart returns the start of the period containing t
+func PeriodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PERIOD_WEEK:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PERIOD_MONTH:
+		return day.AddDate(0, 0, 1 - day.Day())
+	}
+	return day
+}
+
+// next period's start, given a period start
+func nextPeriodStart(period string, start time.Time) time.Time {
+	switch period {
+	case PERIOD_WEEK:
+		return start.AddDate(0, 0, 7)
+	case PERIOD_MONTH:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// MetricRollup summarizes one metric over the reports of a period
+type MetricRollup struct {
+	Avg	float64	`json:"avg"`
+	Min	int64	`json:"min"`
+	Max	int64	`json:"max"`
+}
+
+// Rollup aggregates the reports generated in one period
+type Rollup struct {
+	Period		string					`json:"period"`
+	PeriodStart	time.Time				`json:"periodStart"`
+	Reports		int						`json:"reports"`
+	// metric column -> summary
+	Metrics		map[string]MetricRollup	`json:"metrics"`
+}
+
+// RollupFilter selects rollups of one period starting in [Since, Until);
+// zero times leave that end open
+type RollupFilter struct {
+	Period	string
+	Since	time.Time
+	Until	time.Time
+}
+
+// rollups of schema.Table are kept in <kind>_rollups
+func (s Schema[R]) rollupTable() string {
+	return strings.TrimSuffix(s.Table, "_reports") + "_rollups"
+}
+
+func (s Schema[R]) createRollupTableStatement() string {
+	definitions := []string{
+		"period VARCHAR(8) NOT NULL",
+		"period_start DATE NOT NULL",
+		"reports INT UNSIGNED NOT NULL",
+		rollupFinalColumn.Name + " " + rollupFinalColumn.Definition,
+	}
+	for _, metric := range s.Metrics {
+		definitions = append(definitions, metric + "_avg DOUBLE",
+			metric + "_min BIGINT", metric + "_max BIGINT")
+	}
+	definitions = append(definitions, "PRIMARY KEY (period, period_start)")
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n);", s.rollupTable(),
+		strings.Join(definitions, ",\n\t"))
+}
+
+func (s Schema[R]) rollupColumns() []string {
+	columns := []string{"period", "period_start", "reports"}
+	for _, metric := range s.Metrics {
+		columns = append(columns, metric + "_avg", metric + "_min", metric + "_max")
+	}
+	return columns
+}
+
+// recompute the daily rollups of the reports created in [?, ?); the third
+// argument is the start of the current day, before which a day is final
+func (s Schema[R]) refreshDailyRollupsStatement() string {
+	aggregates := []string{"'" + PERIOD_DAY + "'", "DATE(created_at) AS rollup_start", "COUNT(*)"}
+	for _, metric := range s.Metrics {
+		aggregates = append(aggregates, "COALESCE(AVG(" + metric + "), 0)",
+			"COALESCE(MIN(" + metric + "), 0)", "COALESCE(MAX(" + metric + "), 0)")
+	}
+	return fmt.Sprintf(`REPLACE INTO %s (%s, final)
+		SELECT %s, MIN(DATE(created_at)) < ? FROM %s
+		WHERE deleted_at IS NULL AND created_at >= ? AND created_at < ?
+		GROUP BY rollup_start`, s.rollupTable(), strings.Join(s.rollupColumns(), ", "),
+		strings.Join(aggregates, ", "), s.Table)
+}
+
+// recompute the weekly or monthly rollups from the daily rollups starting
+// on or after ?; the first argument is the start of the current period,
+// before which a period is final
+func (s Schema[R]) refreshRollupsFromDailiesStatement(period string) string {
+	aggregates := []string{"'" + period + "'", periodStartExpressions[period] + " AS rollup_start",
+		"SUM(reports)"}
+	for _, metric := range s.Metrics {
+		aggregates = append(aggregates, fmt.Sprintf("SUM(%s_avg * reports) / SUM(reports)", metric),
+			"MIN(" + metric + "_min)", "MAX(" + metric + "_max)")
+	}
+	return fmt.Sprintf(`REPLACE INTO %s (%s, final)
+		SELECT %s, MIN(period_start) < ? FROM %s
+		WHERE period = '%s' AND period_start >= ?
+		GROUP BY rollup_start`, s.rollupTable(), strings.Join(s.rollupColumns(), ", "),
+		strings.Join(aggregates, ", "), s.rollupTable(), PERIOD_DAY)
+}
+
+// start of the first period after the last final one, the zero time if
+// none is final yet
+func (db *mysqlDB[R]) firstOpenPeriod(ctx context.Context, period string) (time.Time, error) {
+	table := db.schema.rollupTable()
+	var last sql.NullTime
+	err := db.conn.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT MAX(period_start) FROM %s WHERE period = ? AND final", table), period).Scan(&last)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("mysql: could not read %s: %v", table, err)
+	}
+	if !last.Valid {
+		return time.Time{}, nil
+	}
+	return nextPeriodStart(period, last.Time), nil
+}
+
+// RefreshRollups recomputes every rollup that is not final yet, up to now.
+// Days are rolled up from the reports and become final once they have
+// closed; weeks and months are summed from the days. Final rollups are
+// never rewritten, so they outlive the reports they were computed from;
+// Prune refreshes first for that reason.
+func (db *mysqlDB[R]) RefreshRollups(ctx context.Context, now time.Time) (refreshed int64, err error) {
+	table := db.schema.rollupTable()
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(table, "refresh", start, err)
+	}()
+
+	now = now.UTC()
+	for _, period := range Periods {
+		from, err := db.firstOpenPeriod(ctx, period)
+		if err != nil {
+			return refreshed, err
+		}
+		current := PeriodStart(period, now)
+
+		var result sql.Result
+		if period == PERIOD_DAY {
+			result, err = db.conn.ExecContext(ctx, db.schema.refreshDailyRollupsStatement(),
+				current, from, now)
+		} else {
+			result, err = db.conn.ExecContext(ctx, db.schema.refreshRollupsFromDailiesStatement(period),
+				current, from)
+		}
+		if err != nil {
+			return refreshed, fmt.Errorf("mysql: could not refresh %s: %v", table, err)
+		}
+		// REPLACE counts a replaced row twice
+		affected, _ := result.RowsAffected()
+		refreshed += affected
+	}
+	return refreshed, nil
+}
+
+// ListRollups returns the rollups matching filter, oldest first
+func (db *mysqlDB[R]) ListRollups(ctx context.Context, filter RollupFilter) (rollups []Rollup, err error) {
+	table := db.schema.rollupTable()
+	start := time.Now()
+	defer func() {
+		metrics.ObserveDBQuery(table, "list", start, err)
+	}()
+
+	where := []string{"period = ?"}
+	args := []interface{}{filter.Period}
+	if !filter.Since.IsZero() {
+		where = append(where, "period_start >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "period_start < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	rows, err := db.conn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY period_start",
+		strings.Join(db.schema.rollupColumns(), ", "), table, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not list %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	rollups = make([]Rollup, 0)
+	for rows.Next() {
+		rollup := Rollup{Metrics: make(map[string]MetricRollup, len(db.schema.Metrics))}
+		summaries := make([]MetricRollup, len(db.schema.Metrics))
+		dest := []interface{}{&rollup.Period, &rollup.PeriodStart, &rollup.Reports}
+		for i := range summaries {
+			dest = append(dest, &summaries[i].Avg, &summaries[i].Min, &summaries[i].Max)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("mysql: could not read %s: %v", table, err)
+		}
+		for i, metric := range db.schema.Metrics {
+			rollup.Metrics[metric] = summaries[i]
+		}
+		rollups = append(rollups, rollup)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("mysql: could not list %s: %v", table, err)
+	}
+	return rollups, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github/godspeedkil/admin-report/audit"
+	"github/godspeedkil/admin-report/auth"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/report"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wraps a handler with auditing, authentication and a role check
+type adminRouteFunc func(action audit.Action, reportType string, role auth.Role, h http.Handler) http.Handler
+
+// reportRoutes serves the create, list and get endpoints of one report kind.
+// Optional renderers left nil make that format unavailable.
+type reportRoutes[R any] struct {
+	// report type in audit entries, messages and file names
+	name		string
+	// path below /admin the routes are registered at
+	path		string
+	// RATE_LIMIT_* route name applied to create
+	rateLimit	string
+	db			report.Database[R]
+	generate	func(context.Context) (R, error)
+	// stores a generated report, undoing what generate stored besides if
+	// that fails
+	save		func(context.Context, *R) (int64, error)
+	csvHeader	[]string
+	csvRecord	func(*R) []string
+	renderHTML	func(http.ResponseWriter, *R) error
+	renderPDF	func(http.ResponseWriter, *R) error
+	// where the upstream payloads reports are generated from are kept;
+	// nil if they are not
+	archive		report.Archive
+}
+
+// register adds the kind's routes; list and rollups come before {reportId}
+// so that they are not taken for an id
+func (routes reportRoutes[R]) register(admin *mux.Router, adminRoute adminRouteFunc, cfg config) {
+	admin.Methods("GET").Path(routes.path).
+		Handler(adminRoute(audit.ActionCreate, routes.name, auth.RoleOperator,
+			newRateLimiter(routes.rateLimit, cfg).limit(appHandler(routes.create))))
+	admin.Methods("GET").Path(routes.path + "/list").
+		Handler(adminRoute(audit.ActionList, routes.name, auth.RoleViewer,
+			appHandler(routes.list)))
+	admin.Methods("GET").Path(routes.path + "/rollups").
+		Handler(adminRoute(audit.ActionList, routes.name, auth.RoleViewer,
+			appHandler(routes.rollups)))
+	admin.Methods("GET").Path(routes.path + "/{reportId}").
+		Handler(adminRoute(audit.ActionRead, routes.name, auth.RoleViewer,
+			appHandler(routes.get)))
+	if routes.archive != nil {
+		admin.Methods("GET").Path(routes.path + "/{reportId}/input").
+			Handler(adminRoute(audit.ActionExport, routes.name, auth.RoleViewer,
+				appHandler(routes.input)))
+	}
+	admin.Methods("DELETE").Path(routes.path + "/{reportId}").
+		Handler(adminRoute(audit.ActionDelete, routes.name, auth.RoleAdmin,
+			appHandler(routes.delete)))
+	// a full upstream fetch like create, so it shares create's limits
+	admin.Methods("POST").Path(routes.path + "/{reportId}/regenerate").
+		Handler(adminRoute(audit.ActionRegenerate, routes.name, auth.RoleAdmin,
+			newRateLimiter(routes.rateLimit, cfg).limit(appHandler(routes.regenerate))))
+}
+
+func (routes reportRoutes[R]) formats(single bool) []string {
+	formats := []string{FORMAT_JSON}
+	if routes.csvRecord != nil {
+		formats = append(formats, FORMAT_CSV)
+	}
+	if single && routes.renderHTML != nil {
+		formats = append(formats, FORMAT_HTML)
+	}
+	if single && routes.renderPDF != nil {
+		formats = append(formats, FORMAT_PDF)
+	}
+	return formats
+}
+
+func (routes reportRoutes[R]) get(w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	reportId, err := strconv.ParseInt(vars["reportId"], DECIMAL_BASE, INT64_BITS)
+	if err != nil {
+		return badRequestf(err, "reportId must be an integer")
+	}
+	audit.SetReportID(r.Context(), reportId)
+	rep, err := routes.db.Get(r.Context(), reportId)
+	if err == report.ErrReportNotFound {
+		return notFoundf(err, "%s report %d not found", routes.name, reportId)
+	}
+	if err != nil {
+		return appErrorf(err, "could not get %s report", routes.name)
+	}
+
+	format, e := responseFormat(r, routes.formats(true)...)
+	if e != nil {
+		return e
+	}
+	switch format {
+	case FORMAT_CSV:
+		audit.SetAction(r.Context(), audit.ActionExport)
+		writeCSV(w, fmt.Sprintf("%s-report-%d.csv", routes.name, reportId),
+			routes.csvHeader, [][]string{routes.csvRecord(rep)})
+		return nil
+	case FORMAT_HTML:
+		if err := routes.renderHTML(w, rep); err != nil {
+			return appErrorf(err, "could not render %s report", routes.name)
+		}
+		return nil
+	case FORMAT_PDF:
+		audit.SetAction(r.Context(), audit.ActionExport)
+		if err := routes.renderPDF(w, rep); err != nil {
+			return appErrorf(err, "could not render %s report", routes.name)
+		}
+		return nil
+	}
+	writeJSON(w, rep)
+	return nil
+}
+
+func (routes reportRoutes[R]) list(w http.ResponseWriter, r *http.Request) *appError {
+	limit, offset, e := pagination(r)
+	if e != nil {
+		return e
+	}
+	format, e := responseFormat(r, routes.formats(false)...)
+	if e != nil {
+		return e
+	}
+	reports, err := routes.db.List(r.Context(), limit, offset)
+	if err != nil {
+		return appErrorf(err, "could not list %s reports", routes.name)
+	}
+
+	if format == FORMAT_CSV {
+		audit.SetAction(r.Context(), audit.ActionExport)
+		records := make([][]string, len(reports))
+		for i, rep := range reports {
+			records[i] = routes.csvRecord(rep)
+		}
+		writeCSV(w, routes.name + "-reports.csv", routes.csvHeader, records)
+		return nil
+	}
+	writeJSON(w, reports)
+	return nil
+}
+
+// rollups returns the ?period= (day, week or month; day by default) rollups,
+// oldest first, optionally bounded by ?since= and ?until= (RFC 3339)
+func (routes reportRoutes[R]) rollups(w http.ResponseWriter, r *http.Request) *appError {
+	query := r.URL.Query()
+	filter := report.RollupFilter{Period: query.Get("period")}
+	if filter.Period == "" {
+		filter.Period = report.PERIOD_DAY
+	}
+	if !slices.Contains(report.Periods, filter.Period) {
+		return badRequestf(fmt.Errorf("invalid period %q", filter.Period),
+			"period must be one of %s", strings.Join(report.Periods, ", "))
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return badRequestf(err, "since must be an RFC 3339 timestamp")
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return badRequestf(err, "until must be an RFC 3339 timestamp")
+		}
+	}
+
+	rollups, err := routes.db.ListRollups(r.Context(), filter)
+	if err != nil {
+		return appErrorf(err, "could not list %s rollups", routes.name)
+	}
+	writeJSON(w, rollups)
+	return nil
+}
+
+// delete soft-deletes a report; it stays in storage until purged by the
+// retention policy
+func (routes reportRoutes[R]) delete(w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	reportId, err := strconv.ParseInt(vars["reportId"], DECIMAL_BASE, INT64_BITS)
+	if err != nil {
+		return badRequestf(err, "reportId must be an integer")
+	}
+	audit.SetReportID(r.Context(), reportId)
+	err = routes.db.Delete(r.Context(), reportId)
+	if err == report.ErrReportNotFound {
+		return notFoundf(err, "%s report %d not found", routes.name, reportId)
+	}
+	if err != nil {
+		return appErrorf(err, "could not delete %s report", routes.name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// input downloads the upstream payloads a report was generated from, as a
+// gzip-compressed tar
+func (routes reportRoutes[R]) input(w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	reportId, err := strconv.ParseInt(vars["reportId"], DECIMAL_BASE, INT64_BITS)
+	if err != nil {
+		return badRequestf(err, "reportId must be an integer")
+	}
+	audit.SetReportID(r.Context(), reportId)
+	// deleted reports keep their input until purged, but it is not served
+	_, err = routes.db.Get(r.Context(), reportId)
+	if err == report.ErrReportNotFound {
+		return notFoundf(err, "%s report %d not found", routes.name, reportId)
+	}
+	if err != nil {
+		return appErrorf(err, "could not get %s report", routes.name)
+	}
+
+	input, data, err := routes.archive.Get(r.Context(), routes.name, reportId)
+	if err == report.ErrInputNotArchived {
+		return notFoundf(err, "no input archived for %s report %d", routes.name, reportId)
+	}
+	if err != nil {
+		return appErrorf(err, "could not get %s report input", routes.name)
+	}
+	defer data.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": fmt.Sprintf("%s-report-%d-input.tar.gz", routes.name, reportId)}))
+	w.Header().Set("Content-Length", strconv.FormatInt(input.Size, DECIMAL_BASE))
+	w.Header().Set("ETag", `"` + input.SHA256 + `"`)
+	w.Header().Set("X-Content-SHA256", input.SHA256)
+	w.Header().Set("X-Record-Count", strconv.Itoa(input.Records))
+	io.Copy(w, data)
+	return nil
+}
+
+func (routes reportRoutes[R]) create(w http.ResponseWriter, r *http.Request) *appError {
+	reportId, e := routes.generateAndSave(r)
+	if e != nil {
+		return e
+	}
+	audit.SetReportID(r.Context(), reportId)
+	http.Redirect(w, r, fmt.Sprintf("/admin%s/%d", routes.path, reportId),
+		http.StatusFound)
+	return nil
+}
+
+// regenerate replaces a report with one generated from the current
+// upstream data: the new report is saved, then the old one soft-deleted.
+// The audit entry carries the id of the replaced report.
+func (routes reportRoutes[R]) regenerate(w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	oldId, err := strconv.ParseInt(vars["reportId"], DECIMAL_BASE, INT64_BITS)
+	if err != nil {
+		return badRequestf(err, "reportId must be an integer")
+	}
+	audit.SetReportID(r.Context(), oldId)
+	// fail before the upstream fetch if there is nothing to replace
+	_, err = routes.db.Get(r.Context(), oldId)
+	if err == report.ErrReportNotFound {
+		return notFoundf(err, "%s report %d not found", routes.name, oldId)
+	}
+	if err != nil {
+		return appErrorf(err, "could not get %s report", routes.name)
+	}
+
+	reportId, e := routes.generateAndSave(r)
+	if e != nil {
+		return e
+	}
+	// deleted concurrently is as good as replaced
+	err = routes.db.Delete(r.Context(), oldId)
+	if err != nil && err != report.ErrReportNotFound {
+		return appErrorf(err, "could not delete %s report %d replaced by %d",
+			routes.name, oldId, reportId)
+	}
+	logging.FromContext(r.Context()).Info("report regenerated", "kind", routes.name,
+		"replaced", oldId, "reportId", reportId)
+	http.Redirect(w, r, fmt.Sprintf("/admin%s/%d", routes.path, reportId),
+		http.StatusSeeOther)
+	return nil
+}
+
+// generateAndSave generates a report, saves it and archives its input
+func (routes reportRoutes[R]) generateAndSave(r *http.Request) (int64, *appError) {
+	ctx := r.Context()
+	var recorder *report.InputRecorder
+	if routes.archive != nil {
+		var err error
+		if recorder, err = report.NewInputRecorder(); err != nil {
+			logging.FromContext(ctx).Error("could not archive report input",
+				"kind", routes.name, "error", err)
+		} else {
+			defer recorder.Close()
+			ctx = report.WithInputRecorder(ctx, recorder)
+		}
+	}
+	rep, err := routes.generate(ctx)
+	if err != nil {
+		return 0, appErrorf(err, "could not generate %s report", routes.name)
+	}
+
+	reportId, err := routes.save(r.Context(), &rep)
+	if err != nil {
+		return 0, appErrorf(err, "could not save %s report", routes.name)
+	}
+	// the report is saved either way; a missing input only shows as a 404
+	// on download
+	if recorder != nil {
+		if err := routes.archiveInput(r.Context(), recorder, reportId); err != nil {
+			logging.FromContext(r.Context()).Error("could not archive report input",
+				"kind", routes.name, "reportId", reportId, "error", err)
+		}
+	}
+	return reportId, nil
+}
+
+func (routes reportRoutes[R]) archiveInput(ctx context.Context, recorder *report.InputRecorder,
+	reportId int64) error {
+	input, data, err := recorder.Finish(routes.name, reportId, time.Now())
+	if err != nil {
+		return err
+	}
+	return routes.archive.Put(ctx, input, data)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"github/godspeedkil/admin-report/registry"
+	"log/slog"
+	"time"
+)
+
+// a report store whose rollups are kept up to date
+type rollupTarget struct {
+	kind	string
+	refresh	func(context.Context, time.Time) (int64, error)
+}
+
+// rollupTargets must be called once the databases are open
+func rollupTargets() []rollupTarget {
+	targets := make([]rollupTarget, 0, len(registry.All))
+	for _, kind := range registry.All {
+		targets = append(targets, rollupTarget{kind.Name(), kind.RefreshRollups})
+	}
+	return targets
+}
+
+// runRollups refreshes every kind's rollups every interval until ctx is
+// done, starting right away
+func runRollups(ctx context.Context, logger *slog.Logger, interval time.Duration,
+	targets []rollupTarget) {
+	logger = logger.With("job", "rollups")
+	logger.Info("rollup refresher started", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		refreshRollupsOnce(ctx, logger, targets)
+		select {
+		case <-ctx.Done():
+			logger.Info("rollup refresher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func refreshRollupsOnce(ctx context.Context, logger *slog.Logger, targets []rollupTarget) {
+	now := time.Now()
+	for _, target := range targets {
+		if ctx.Err() != nil {
+			return
+		}
+		refreshed, err := target.refresh(ctx, now)
+		if err != nil {
+			logger.Error("could not refresh rollups", "kind", target.kind, "error", err)
+			continue
+		}
+		logger.Debug("rollups refreshed", "kind", target.kind, "rows", refreshed)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/tasks"
+	"net/http"
+	"strconv"
+)
+
+var errNoReports = errors.New("no reports stored yet")
+
+// snapshotPDFHandler renders a stored habits report and a stored tasks
+// report as one PDF. ?habitsReport= and ?tasksReport= select them by ID,
+// the latest of each is used otherwise.
+func snapshotPDFHandler(w http.ResponseWriter, r *http.Request) *appError {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	var habitsReport *habits.HabitsReport
+	if value := query.Get("habitsReport"); value != "" {
+		reportId, err := strconv.ParseInt(value, DECIMAL_BASE, INT64_BITS)
+		if err != nil {
+			return badRequestf(err, "habitsReport must be an integer")
+		}
+		habitsReport, err = habits.DB.Get(ctx, reportId)
+		if err == report.ErrReportNotFound {
+			return notFoundf(err, "habits report %d not found", reportId)
+		}
+		if err != nil {
+			return appErrorf(err, "could not get habits report")
+		}
+	} else {
+		latest, err := habits.DB.List(ctx, 1, 0)
+		if err != nil {
+			return appErrorf(err, "could not get habits report")
+		}
+		if len(latest) == 0 {
+			return notFoundf(errNoReports, "no habits report has been generated yet")
+		}
+		habitsReport = latest[0]
+	}
+
+	var tasksReport *tasks.TasksReport
+	if value := query.Get("tasksReport"); value != "" {
+		reportId, err := strconv.ParseInt(value, DECIMAL_BASE, INT64_BITS)
+		if err != nil {
+			return badRequestf(err, "tasksReport must be an integer")
+		}
+		tasksReport, err = tasks.DB.Get(ctx, reportId)
+		if err == report.ErrReportNotFound {
+			return notFoundf(err, "tasks report %d not found", reportId)
+		}
+		if err != nil {
+			return appErrorf(err, "could not get tasks report")
+		}
+	} else {
+		latest, err := tasks.DB.List(ctx, 1, 0)
+		if err != nil {
+			return appErrorf(err, "could not get tasks report")
+		}
+		if len(latest) == 0 {
+			return notFoundf(errNoReports, "no tasks report has been generated yet")
+		}
+		tasksReport = latest[0]
+	}
+
+	if err := renderSnapshotPDF(w, habitsReport, tasksReport); err != nil {
+		return appErrorf(err, "could not render summary")
+	}
+	return nil
+}
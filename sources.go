@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"github/godspeedkil/admin-report/sources"
+	"log/slog"
+)
+
+// openSources points habits.Source and tasks.Source at the configured
+// sources; if one fails, those already open are closed again
+func openSources(ctx context.Context, cfg config, logger *slog.Logger) error {
+	for i, name := range sources.Names {
+		if err := sources.Open(ctx, name, cfg.Sources[name]); err != nil {
+			closeSourceNames(logger, sources.Names[:i])
+			return err
+		}
+	}
+	logger.Info("upstream sources configured", "habits", cfg.Sources["habits"].Kind,
+		"tasks", cfg.Sources["tasks"].Kind)
+	return nil
+}
+
+// closeSources reports whether every source closed cleanly
+func closeSources(logger *slog.Logger) bool {
+	return closeSourceNames(logger, sources.Names)
+}
+
+func closeSourceNames(logger *slog.Logger, names []string) bool {
+	ok := true
+	for _, name := range names {
+		if err := sources.Close(name); err != nil {
+			logger.Error("could not close source", "source", name, "error", err)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,171 @@
+// Package sources reads where habits and tasks are read from, where the
+// accounts service is, and how upstream listings are paged, from the
+// environment; the server and reportctl share it so that both honour the
+// same settings.
+package sources
+
+import (
+	"context"
+	"fmt"
+	"github/godspeedkil/admin-report/accounts"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/tasks"
+	"github/godspeedkil/admin-report/upstream"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// kinds of Config
+const (
+	SOURCE_HTTP = "http"
+	SOURCE_FILE = "file"
+	SOURCE_SQL = "sql"
+)
+
+// Names are the record sources, in the order they are opened
+var Names = []string{"habits", "tasks"}
+
+// source name -> service URL and SQL query used when none is configured
+var defaults = map[string]struct{ url, query string }{
+	"habits":	{habits.HABITS_URL, habits.DEFAULT_SQL_QUERY},
+	"tasks":	{tasks.TASKS_URL, tasks.DEFAULT_SQL_QUERY},
+}
+
+// Config selects where habits or tasks are read from
+type Config struct {
+	// SOURCE_HTTP, SOURCE_FILE or SOURCE_SQL
+	Kind	string
+	URL		string
+	// how the service at URL is paged, for SOURCE_HTTP
+	Paging	upstream.Paging
+	// JSON array or NDJSON export, for SOURCE_FILE
+	Path	string
+	// go-sql-driver DSN and query, for SOURCE_SQL
+	DSN		string
+	Query	string
+}
+
+// LoadPaging reads <NAME>_PAGINATION=none|page|cursor and
+// <NAME>_PAGE_SIZE=count for the upstream service name
+func LoadPaging(name string) (upstream.Paging, error) {
+	prefix := strings.ToUpper(name)
+	pageSize := upstream.DEFAULT_PAGE_SIZE
+	if value := os.Getenv(prefix + "_PAGE_SIZE"); value != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(value); err != nil {
+			return upstream.Paging{}, fmt.Errorf("config: %s_PAGE_SIZE must be an integer, got %q",
+				prefix, value)
+		}
+	}
+	paging, err := upstream.ParsePaging(os.Getenv(prefix + "_PAGINATION"), pageSize)
+	if err != nil {
+		return paging, fmt.Errorf("config: %s_PAGINATION: %v", prefix, err)
+	}
+	return paging, nil
+}
+
+// LoadAccounts reads ACCOUNTS_URL and the paging settings of the accounts
+// service
+func LoadAccounts() (url string, paging upstream.Paging, err error) {
+	paging, err = LoadPaging("accounts")
+	return envString("ACCOUNTS_URL", accounts.ACCOUNTS_URL), paging, err
+}
+
+// Load reads <NAME>_SOURCE=http|file|sql with <NAME>_URL, <NAME>_FILE,
+// <NAME>_SQL_DSN, <NAME>_SQL_QUERY and the paging settings of source name
+func Load(name string) (Config, error) {
+	fallback, ok := defaults[name]
+	if !ok {
+		return Config{}, fmt.Errorf("config: unknown source %q", name)
+	}
+	prefix := strings.ToUpper(name)
+	c := Config{
+		Kind:	envString(prefix + "_SOURCE", SOURCE_HTTP),
+		URL:	envString(prefix + "_URL", fallback.url),
+		Path:	os.Getenv(prefix + "_FILE"),
+		DSN:	os.Getenv(prefix + "_SQL_DSN"),
+		Query:	envString(prefix + "_SQL_QUERY", fallback.query),
+	}
+	var err error
+	if c.Paging, err = LoadPaging(name); err != nil {
+		return c, err
+	}
+	switch {
+	case c.Kind != SOURCE_HTTP && c.Kind != SOURCE_FILE && c.Kind != SOURCE_SQL:
+		return c, fmt.Errorf("config: %s_SOURCE must be %s, %s or %s, got %q",
+			prefix, SOURCE_HTTP, SOURCE_FILE, SOURCE_SQL, c.Kind)
+	case c.Kind == SOURCE_FILE && c.Path == "":
+		return c, fmt.Errorf("config: %s_SOURCE=%s needs %s_FILE", prefix, SOURCE_FILE, prefix)
+	case c.Kind == SOURCE_SQL && c.DSN == "":
+		return c, fmt.Errorf("config: %s_SOURCE=%s needs %s_SQL_DSN", prefix, SOURCE_SQL, prefix)
+	}
+	return c, nil
+}
+
+// Override reads from the file input if set, else from the service at url
+// if set, keeping the configured paging; with neither c is unchanged
+func (c Config) Override(input, url string) Config {
+	if input != "" {
+		return Config{Kind: SOURCE_FILE, Path: input}
+	}
+	if url != "" {
+		return Config{Kind: SOURCE_HTTP, URL: url, Paging: c.Paging}
+	}
+	return c
+}
+
+// Open points habits.Source or tasks.Source, after name, at c
+func Open(ctx context.Context, name string, c Config) error {
+	var err error
+	switch name {
+	case "habits":
+		switch c.Kind {
+		case SOURCE_FILE:
+			habits.Source = habits.FileSource{Path: c.Path}
+		case SOURCE_SQL:
+			var source *habits.SQLSource
+			if source, err = habits.NewSQLSource(ctx, c.DSN, c.Query); err == nil {
+				habits.Source = source
+			}
+		default:
+			habits.Source = habits.HTTPSource{URL: c.URL, Paging: c.Paging}
+		}
+	case "tasks":
+		switch c.Kind {
+		case SOURCE_FILE:
+			tasks.Source = tasks.FileSource{Path: c.Path}
+		case SOURCE_SQL:
+			var source *tasks.SQLSource
+			if source, err = tasks.NewSQLSource(ctx, c.DSN, c.Query); err == nil {
+				tasks.Source = source
+			}
+		default:
+			tasks.Source = tasks.HTTPSource{URL: c.URL, Paging: c.Paging}
+		}
+	default:
+		return fmt.Errorf("unknown source %q", name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s source: %v", name, err)
+	}
+	return nil
+}
+
+// Close closes the source Open set for name
+func Close(name string) error {
+	switch name {
+	case "habits":
+		return habits.Source.Close()
+	case "tasks":
+		return tasks.Source.Close()
+	}
+	return fmt.Errorf("unknown source %q", name)
+}
+
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,4 @@
+package summary
+
+// DB holds the summary reports; opened at startup by whoever serves them
+var DB SummaryReportDatabase
@@ -0,0 +1,62 @@
+package summary
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github/godspeedkil/admin-report/report"
+	"log/slog"
+)
+
+// schema stores one summary per row of summary_reports, the per-user
+// breakdown as a JSON document
+var schema = report.Schema[SummaryReport]{
+	Table: "summary_reports",
+	Columns: []report.Column{
+		{Name: "habits_report_id", Definition: "INT UNSIGNED NOT NULL"},
+		{Name: "tasks_report_id", Definition: "INT UNSIGNED NOT NULL"},
+		{Name: "at_risk_users", Definition: "INT UNSIGNED"},
+		{Name: "users", Definition: "MEDIUMTEXT"},
+		{Name: "created_at", Definition: "DATETIME(3)"},
+	},
+	Values: summaryReportValues,
+	Scan: scanSummaryReport,
+	Metrics: []string{"at_risk_users"},
+}
+
+func summaryReportValues(r *SummaryReport) []interface{} {
+	// a slice of plain structs always encodes
+	users, _ := json.Marshal(r.Users)
+	return []interface{}{r.HabitsReportID, r.TasksReportID, r.AtRiskUsers,
+		string(users), r.GeneratedAt}
+}
+
+func scanSummaryReport(s report.RowScanner) (*SummaryReport, error) {
+	var (
+		summary		SummaryReport
+		atRisk		sql.NullInt64
+		users		sql.NullString
+		createdAt	sql.NullTime
+	)
+	if err := s.Scan(&summary.ReportID, &summary.HabitsReportID, &summary.TasksReportID,
+		&atRisk, &users, &createdAt); err != nil {
+		return nil, err
+	}
+
+	summary.AtRiskUsers = int(atRisk.Int64)
+	summary.Users = make([]UserSummary, 0)
+	if users.Valid && users.String != "" {
+		if err := json.Unmarshal([]byte(users.String), &summary.Users); err != nil {
+			return nil, fmt.Errorf("could not decode users: %v", err)
+		}
+	}
+	if createdAt.Valid {
+		summary.GeneratedAt = &createdAt.Time
+	}
+	return &summary, nil
+}
+
+// Open connects to the summary reports table, creating it if needed
+func Open(config report.MySQLConfig, logger *slog.Logger) (SummaryReportDatabase, error) {
+	return report.NewMySQLDB(config, schema, logger)
+}
@@ -0,0 +1,193 @@
+package summary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github/godspeedkil/admin-report/habits"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/report"
+	"github/godspeedkil/admin-report/tasks"
+	"sort"
+	"sync"
+	"time"
+)
+
+// a user is at risk with at least this many delayed tasks and red habits
+const (
+	AT_RISK_DELAYED_TASKS = 3
+	AT_RISK_RED_HABITS = 1
+)
+
+// UserSummary correlates one user's habits and tasks
+type UserSummary struct {
+	UserID			string	`json:"userID"`
+	Habits			int		`json:"habits"`
+	RedHabits		int		`json:"redHabits"`
+	Tasks			int		`json:"tasks"`
+	DelayedTasks	int		`json:"delayedTasks"`
+	CompletedLate	int		`json:"completedLate"`
+	AtRisk			bool	`json:"atRisk"`
+}
+
+// SummaryReport is a combined snapshot referencing the habits and tasks
+// reports generated with it
+type SummaryReport struct {
+	ReportID		int64			`json:"reportID"`
+	HabitsReportID	int64			`json:"habitsReportID"`
+	TasksReportID	int64			`json:"tasksReportID"`
+	AtRiskUsers		int				`json:"atRiskUsers"`
+	// at risk users first, then by delayed tasks and red habits
+	Users			[]UserSummary	`json:"users"`
+	GeneratedAt		*time.Time		`json:"generatedAt,omitempty"`
+}
+
+type SummaryReportDatabase = report.Database[SummaryReport]
+
+// GenerateSummaryReport streams habits and tasks concurrently, stores a
+// habits and a tasks report built from them, and correlates both datasets
+// by user. Storing the summary is left to the caller, who must call
+// DiscardSubReports if that fails so that the two reports are not left
+// unreferenced. Only per-user counts are held in memory, never the records
+// themselves.
+func GenerateSummaryReport(ctx context.Context) (summaryReport SummaryReport, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveReportGeneration("summary", start, err)
+	}()
+
+	var (
+		wg					sync.WaitGroup
+		habitsAggregator	= habits.NewAggregator()
+		habitsByUser		= make(map[string]*UserSummary)
+		tasksByUser			= make(map[string]*UserSummary)
+		habitsErr			error
+		tasksErr			error
+	)
+	// delayed per user and in the tasks report agree on what is overdue
+	now := time.Now()
+	tasksAggregator := tasks.NewAggregatorAt(now)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		habitsErr = habits.StreamHabits(ctx, func(habit habits.Habit) error {
+			habitsAggregator.Add(habit)
+			if len(habit.Validate()) > 0 {
+				return nil
+			}
+			summary := userSummary(habitsByUser, habit.UserID)
+			summary.Habits++
+			if habit.Color == habits.COLOR_RED {
+				summary.RedHabits++
+			}
+			return nil
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		tasksErr = tasks.StreamTasks(ctx, func(task tasks.Task) error {
+			tasksAggregator.Add(task)
+			if len(task.Validate()) > 0 {
+				return nil
+			}
+			summary := userSummary(tasksByUser, task.UserID)
+			summary.Tasks++
+			if task.CompletedDate == nil && task.DueDate < now.Unix() {
+				summary.DelayedTasks++
+			}
+			if task.CompletedDate != nil && *task.CompletedDate > task.DueDate {
+				summary.CompletedLate++
+			}
+			return nil
+		})
+	}()
+	wg.Wait()
+	if habitsErr != nil {
+		return summaryReport, habitsErr
+	}
+	if tasksErr != nil {
+		return summaryReport, tasksErr
+	}
+
+	habitsReport := habitsAggregator.Report()
+	if summaryReport.HabitsReportID, err = habits.DB.Add(ctx, &habitsReport); err != nil {
+		return summaryReport, fmt.Errorf("summary: could not store habits report: %v", err)
+	}
+	tasksReport := tasksAggregator.Report()
+	if summaryReport.TasksReportID, err = tasks.DB.Add(ctx, &tasksReport); err != nil {
+		err = fmt.Errorf("summary: could not store tasks report: %v", err)
+		if deleteErr := habits.DB.Delete(ctx, summaryReport.HabitsReportID); deleteErr != nil {
+			err = errors.Join(err, fmt.Errorf("summary: could not delete habits report %d: %v",
+				summaryReport.HabitsReportID, deleteErr))
+		}
+		return summaryReport, err
+	}
+
+	generatedAt := time.Now().UTC()
+	summaryReport.GeneratedAt = &generatedAt
+	summaryReport.Users = correlateUsers(habitsByUser, tasksByUser)
+	for _, user := range summaryReport.Users {
+		if user.AtRisk {
+			summaryReport.AtRiskUsers++
+		}
+	}
+
+	logging.FromContext(ctx).Debug("summary generated",
+		"habitsReportID", summaryReport.HabitsReportID,
+		"tasksReportID", summaryReport.TasksReportID,
+		"users", len(summaryReport.Users), "atRisk", summaryReport.AtRiskUsers)
+	return summaryReport, nil
+}
+
+// DiscardSubReports deletes the habits and tasks reports generated with
+// summaryReport, for when the summary itself could not be stored
+func DiscardSubReports(ctx context.Context, summaryReport *SummaryReport) error {
+	var errs []error
+	if err := habits.DB.Delete(ctx, summaryReport.HabitsReportID); err != nil {
+		errs = append(errs, fmt.Errorf("summary: could not delete habits report %d: %v",
+			summaryReport.HabitsReportID, err))
+	}
+	if err := tasks.DB.Delete(ctx, summaryReport.TasksReportID); err != nil {
+		errs = append(errs, fmt.Errorf("summary: could not delete tasks report %d: %v",
+			summaryReport.TasksReportID, err))
+	}
+	return errors.Join(errs...)
+}
+
+func userSummary(byUser map[string]*UserSummary, userId string) *UserSummary {
+	summary, ok := byUser[userId]
+	if !ok {
+		summary = &UserSummary{UserID: userId}
+		byUser[userId] = summary
+	}
+	return summary
+}
+
+// correlateUsers joins the habits and tasks counts on their user ID
+func correlateUsers(habitsByUser, tasksByUser map[string]*UserSummary) []UserSummary {
+	for userId, counts := range tasksByUser {
+		summary := userSummary(habitsByUser, userId)
+		summary.Tasks = counts.Tasks
+		summary.DelayedTasks = counts.DelayedTasks
+		summary.CompletedLate = counts.CompletedLate
+	}
+
+	users := make([]UserSummary, 0, len(habitsByUser))
+	for _, summary := range habitsByUser {
+		summary.AtRisk = summary.DelayedTasks >= AT_RISK_DELAYED_TASKS &&
+			summary.RedHabits >= AT_RISK_RED_HABITS
+		users = append(users, *summary)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if a.AtRisk != b.AtRisk {
+			return a.AtRisk
+		}
+		if a.DelayedTasks+a.RedHabits != b.DelayedTasks+b.RedHabits {
+			return a.DelayedTasks+a.RedHabits > b.DelayedTasks+b.RedHabits
+		}
+		return a.UserID < b.UserID
+	})
+	return users
+}
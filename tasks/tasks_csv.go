@@ -0,0 +1,34 @@
+package tasks
+
+import (
+	"strconv"
+	"time"
+)
+
+// CSVHeader names the columns written by CSVRecord
+var CSVHeader = []string{
+	"report_id", "generated_at", "valid_records", "invalid_records",
+	"completed_total", "completed_on_time", "completed_late",
+	"delayed",
+	"available_total", "available_due_today",
+}
+
+// CSVRecord flattens the report into one row matching CSVHeader
+func (r *TasksReport) CSVRecord() []string {
+	var generatedAt string
+	if r.GeneratedAt != nil {
+		generatedAt = r.GeneratedAt.UTC().Format(time.RFC3339)
+	}
+	var validRecords, invalidRecords string
+	if r.DataQuality != nil {
+		validRecords = strconv.Itoa(r.DataQuality.Valid)
+		invalidRecords = strconv.Itoa(r.DataQuality.Invalid)
+	}
+	return []string{
+		strconv.FormatInt(r.ReportID, 10), generatedAt, validRecords, invalidRecords,
+		strconv.Itoa(r.Completed.Total), strconv.Itoa(r.Completed.OnTime),
+		strconv.Itoa(r.Completed.Late),
+		strconv.Itoa(r.Delayed),
+		strconv.Itoa(r.Available.Total), strconv.Itoa(r.Available.DueToday),
+	}
+}
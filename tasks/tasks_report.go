@@ -1,9 +1,12 @@
 package tasks
 
 import (
-	"net/http"
-	"errors"
+	"context"
 	"encoding/json"
+	"fmt"
+	"github/godspeedkil/admin-report/logging"
+	"github/godspeedkil/admin-report/metrics"
+	"github/godspeedkil/admin-report/report"
 	"time"
 )
 
@@ -13,6 +16,8 @@ const (
 )
 
 type Task struct {
+	// see UnmarshalJSON for the keys it is read from
+	TaskID			string		`json:"id"`
 	CompletedDate 	*int64		`json:"completedDate"`
 	Description 	string 		`json:"description"`
 	DueDate 		int64 		`json:"dueDate"`
@@ -21,6 +26,39 @@ type Task struct {
 	UserID			string		`json:"userId"`
 }
 
+// UnmarshalJSON reads the task id from "id" or, as the habits service names
+// it, "_id", as a string or a number. The tasks service does not document
+// its key, and an id left empty would make data quality samples useless.
+func (t *Task) UnmarshalJSON(data []byte) error {
+	type plain Task
+	var raw struct {
+		plain
+		// shadow plain.TaskID
+		ID		json.RawMessage	`json:"id"`
+		MongoID	json.RawMessage	`json:"_id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*t = Task(raw.plain)
+	id := raw.ID
+	if len(id) == 0 || string(id) == "null" {
+		id = raw.MongoID
+	}
+	if len(id) == 0 || string(id) == "null" {
+		return nil
+	}
+	if id[0] == '"' {
+		return json.Unmarshal(id, &t.TaskID)
+	}
+	var number json.Number
+	if err := json.Unmarshal(id, &number); err != nil {
+		return fmt.Errorf("task id: %v", err)
+	}
+	t.TaskID = number.String()
+	return nil
+}
+
 type CompletedDescription struct {
 	Total	int	`json:"total"`
 	OnTime	int	`json:"onTime"`
@@ -37,85 +75,142 @@ type TasksReport struct {
 	Completed		CompletedDescription	`json:"completed"`
 	Delayed			int						`json:"delayed"`
 	Available		AvailableDescription	`json:"available"`
+	// nil for reports stored before generation times were recorded
+	GeneratedAt		*time.Time				`json:"generatedAt,omitempty"`
+	// nil for reports stored before records were validated
+	DataQuality		*report.DataQuality		`json:"dataQuality,omitempty"`
 }
 
-type TasksReportDatabase interface {
-	AddTasksReport(*TasksReport) (reportId int64, err error)
+type TasksReportDatabase = report.Database[TasksReport]
 
-	GetTasksReport(reportId int64)	(*TasksReport, error)
+// Kind generates tasks reports from Source
+var Kind = report.Kind[Task, TasksReport]{
+	Name:			"tasks",
+	Fetch:			StreamTasks,
+	NewAggregator:	func() report.Aggregator[Task, TasksReport] { return NewAggregator() },
+	Schema:			schema,
+}
 
-	Close()
+// PingUpstream checks that Source can be read
+func PingUpstream(ctx context.Context) error {
+	return Source.Ping(ctx)
 }
 
-func fetchDatabaseAllTasks() ([]Task, error) {
-	resp, err := http.Get(TASKS_URL + "/Task/tasks")
-	if err != nil {
-		return []Task{}, errors.New("Tasks unavailable")
-	}
+// StreamTasks passes every task in Source to yield as it is read
+func StreamTasks(ctx context.Context, yield func(Task) error) (err error) {
+	logger := logging.FromContext(ctx).With("upstream", "tasks")
+	start := time.Now()
+	defer func() {
+		metrics.ObserveUpstreamFetch("tasks", start, err)
+	}()
 
-	jsonArray := make([]Task, 0)
-	decoder := json.NewDecoder(resp.Body)
-	defer resp.Body.Close()
-	if err = decoder.Decode(&jsonArray); err != nil {
-		return []Task{}, errors.New("Error decoding Tasks")
+	count, err := Source.StreamTasks(ctx, yield)
+	if err != nil {
+		logger.Warn("upstream fetch failed", "count", count, "error", err)
+		return fmt.Errorf("Tasks unavailable: %v", err)
 	}
 
-	return jsonArray, nil
+	logger.Debug("upstream fetch complete", "count", count,
+		"duration", time.Since(start))
+	return nil
 }
 
-func populateCompleted(allTasks []Task) CompletedDescription {
-	var completed CompletedDescription
-	for i, _ := range allTasks {
-		if allTasks[i].CompletedDate == nil {
-			continue
-		}
-		if *allTasks[i].CompletedDate <= allTasks[i].DueDate {
-			completed.Total++
-			completed.OnTime++
-		} else if *allTasks[i].CompletedDate > allTasks[i].DueDate {
-			completed.Total++
-			completed.Late++
-		}
+// FetchDatabaseAllTasks returns every task in Source;
+// prefer StreamTasks where the tasks need not all be held at once
+func FetchDatabaseAllTasks(ctx context.Context) ([]Task, error) {
+	allTasks := make([]Task, 0)
+	err := StreamTasks(ctx, func(task Task) error {
+		allTasks = append(allTasks, task)
+		return nil
+	})
+	if err != nil {
+		return []Task{}, err
 	}
+	return allTasks, nil
+}
 
-	return completed
+// Aggregator builds a tasks report in a single pass, one task at a time.
+// Every task is judged against the same reference time, so the counts of a
+// report agree with each other however long the stream takes.
+type Aggregator struct {
+	now			time.Time
+	completed	CompletedDescription
+	delayed		int
+	available	AvailableDescription
+	quality		report.DataQuality
 }
 
-func countDelayed(allTasks []Task) int {
-	var delayedCount int
-	for i, _ := range allTasks {
-		if allTasks[i].CompletedDate == nil && allTasks[i].DueDate < time.Now().Unix() {
-			delayedCount++
-		}
-	}
+// NewAggregator returns an aggregator judging tasks against the current time
+func NewAggregator() *Aggregator {
+	return NewAggregatorAt(time.Now())
+}
 
-	return delayedCount
+// NewAggregatorAt returns an aggregator judging tasks against now; "due
+// today" is the calendar day of now in now's location
+func NewAggregatorAt(now time.Time) *Aggregator {
+	return &Aggregator{now: now, quality: report.NewDataQuality()}
 }
 
-func populateAvailable(allTasks []Task) AvailableDescription {
-	var available AvailableDescription
-	for i,_ := range allTasks {
-		if allTasks[i].CompletedDate == nil {
-			available.Total++
-			if time.Unix(allTasks[i].DueDate, 0).YearDay() == time.Now().YearDay() {
-				available.DueToday++
-			}
+// Add counts a valid task; invalid ones are only recorded in the data
+// quality section
+func (a *Aggregator) Add(task Task) {
+	if !a.quality.Record(task.TaskID, task.Validate()) {
+		return
+	}
+	if task.CompletedDate != nil {
+		a.completed.Total++
+		if *task.CompletedDate <= task.DueDate {
+			a.completed.OnTime++
+		} else {
+			a.completed.Late++
 		}
+		return
 	}
 
-	return available
+	a.available.Total++
+	if task.DueDate < a.now.Unix() {
+		a.delayed++
+	}
+	due := time.Unix(task.DueDate, 0).In(a.now.Location())
+	if due.Year() == a.now.Year() && due.YearDay() == a.now.YearDay() {
+		a.available.DueToday++
+	}
 }
 
-func GenerateTasksReport() (TasksReport, error) {
+// Report returns the counts so far, generated at the reference time
+func (a *Aggregator) Report() TasksReport {
 	var tasksReport TasksReport
-	allTasks, err := fetchDatabaseAllTasks()
-	if err != nil {
-		return tasksReport, err
-	}
+	generatedAt := a.now.UTC()
+	tasksReport.GeneratedAt = &generatedAt
+	tasksReport.Completed = a.completed
+	tasksReport.Delayed = a.delayed
+	tasksReport.Available = a.available
+	quality := a.quality
+	tasksReport.DataQuality = &quality
+
+	recordLatestTasksReport(tasksReport)
+	return tasksReport
+}
 
-	tasksReport.Completed = populateCompleted(allTasks)
-	tasksReport.Delayed = countDelayed(allTasks)
-	tasksReport.Available = populateAvailable(allTasks)
+func GenerateTasksReport(ctx context.Context) (TasksReport, error) {
+	return Kind.Generate(ctx)
+}
+
+// BuildTasksReport aggregates already fetched tasks
+func BuildTasksReport(allTasks []Task) TasksReport {
+	aggregator := NewAggregator()
+	for i := range allTasks {
+		aggregator.Add(allTasks[i])
+	}
+	return aggregator.Report()
+}
 
-	return tasksReport, nil
+// expose the counts of the latest report as gauges
+func recordLatestTasksReport(report TasksReport) {
+	metrics.TasksLatest.WithLabelValues("completed_total").Set(float64(report.Completed.Total))
+	metrics.TasksLatest.WithLabelValues("completed_on_time").Set(float64(report.Completed.OnTime))
+	metrics.TasksLatest.WithLabelValues("completed_late").Set(float64(report.Completed.Late))
+	metrics.TasksLatest.WithLabelValues("delayed").Set(float64(report.Delayed))
+	metrics.TasksLatest.WithLabelValues("available_total").Set(float64(report.Available.Total))
+	metrics.TasksLatest.WithLabelValues("available_due_today").Set(float64(report.Available.DueToday))
 }
\ No newline at end of file
@@ -0,0 +1,218 @@
+package tasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAggregatorAt(t *testing.T) {
+	now := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	at := func(t time.Time) int64 { return t.Unix() }
+	completed := func(t time.Time) *int64 { unix := t.Unix(); return &unix }
+
+	tests := []struct {
+		name			string
+		task			Task
+		wantCompleted	CompletedDescription
+		wantDelayed		int
+		wantAvailable	AvailableDescription
+	}{
+		{
+			name:			"due later today",
+			task:			Task{DueDate: at(now.Add(time.Hour))},
+			wantAvailable:	AvailableDescription{Total: 1, DueToday: 1},
+		},
+		{
+			name:			"due earlier today",
+			task:			Task{DueDate: at(now.Add(-time.Hour))},
+			wantDelayed:	1,
+			wantAvailable:	AvailableDescription{Total: 1, DueToday: 1},
+		},
+		{
+			name:			"same day of the year, a year ago",
+			task:			Task{DueDate: at(now.AddDate(-1, 0, 0))},
+			wantDelayed:	1,
+			wantAvailable:	AvailableDescription{Total: 1},
+		},
+		{
+			name:			"same day of the year, next year",
+			task:			Task{DueDate: at(now.AddDate(1, 0, 0))},
+			wantAvailable:	AvailableDescription{Total: 1},
+		},
+		{
+			name:			"due tomorrow",
+			task:			Task{DueDate: at(now.AddDate(0, 0, 1))},
+			wantAvailable:	AvailableDescription{Total: 1},
+		},
+		{
+			name:			"completed on time",
+			task:			Task{DueDate: at(now), CompletedDate: completed(now.Add(-time.Hour))},
+			wantCompleted:	CompletedDescription{Total: 1, OnTime: 1},
+		},
+		{
+			name:			"completed late",
+			task:			Task{DueDate: at(now.AddDate(0, 0, -2)), CompletedDate: completed(now)},
+			wantCompleted:	CompletedDescription{Total: 1, Late: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.UserID = "u1"
+			aggregator := NewAggregatorAt(now)
+			aggregator.Add(tt.task)
+			got := aggregator.Report()
+			if got.Completed != tt.wantCompleted {
+				t.Errorf("completed = %+v, want %+v", got.Completed, tt.wantCompleted)
+			}
+			if got.Delayed != tt.wantDelayed {
+				t.Errorf("delayed = %d, want %d", got.Delayed, tt.wantDelayed)
+			}
+			if got.Available != tt.wantAvailable {
+				t.Errorf("available = %+v, want %+v", got.Available, tt.wantAvailable)
+			}
+			if !got.GeneratedAt.Equal(now) {
+				t.Errorf("generatedAt = %v, want %v", got.GeneratedAt, now)
+			}
+		})
+	}
+}
+
+func TestTaskUnmarshal(t *testing.T) {
+	// shaped like a record of the tasks service
+	const record = `{"%s":%s,"completedDate":null,"description":"Buy milk",` +
+		`"dueDate":1552219200,"remind":0,"title":"Groceries","userId":"u1"}`
+
+	tests := []struct {
+		name	string
+		key		string
+		value	string
+		want	string
+	}{
+		{"string id", "id", `"5c8e7f1a2b3c4d5e6f708192"`, "5c8e7f1a2b3c4d5e6f708192"},
+		{"numeric id", "id", `42`, "42"},
+		{"mongo style _id", "_id", `"5c8e7f1a2b3c4d5e6f708192"`, "5c8e7f1a2b3c4d5e6f708192"},
+		{"null id", "id", `null`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var task Task
+			if err := json.Unmarshal([]byte(fmt.Sprintf(record, tt.key, tt.value)), &task); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if task.TaskID != tt.want {
+				t.Errorf("TaskID = %q, want %q", task.TaskID, tt.want)
+			}
+			if task.UserID != "u1" || task.DueDate != 1552219200 || task.Title != "Groceries" ||
+				task.CompletedDate != nil {
+				t.Errorf("task = %+v", task)
+			}
+		})
+	}
+
+	var task Task
+	if err := json.Unmarshal([]byte(`{"id":{"oid":1}}`), &task); err == nil {
+		t.Errorf("object id: want an error")
+	}
+}
+
+func TestAggregatorSamplesTaskIDs(t *testing.T) {
+	var task Task
+	err := json.Unmarshal([]byte(`{"id":"t-17","dueDate":1552219200,"title":"Orphan"}`), &task)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	aggregator := NewAggregator()
+	aggregator.Add(task)
+	samples := aggregator.Report().DataQuality.Samples[REJECT_MISSING_USER_ID]
+	if !slices.Equal(samples, []string{"t-17"}) {
+		t.Errorf("samples = %v, want [t-17]", samples)
+	}
+}
+
+// benchmarkTasks spreads due and completion dates over the days around now
+func benchmarkTasks(n int, now time.Time) []Task {
+	tasks := make([]Task, n)
+	for i := range tasks {
+		due := now.Add(time.Duration(i % 96 - 48) * time.Hour).Unix()
+		tasks[i] = Task{
+			TaskID:		strconv.Itoa(i),
+			UserID:		"u" + strconv.Itoa(i % 100),
+			DueDate:	due,
+		}
+		if i % 3 == 0 {
+			completedDate := due + int64(i % 7 - 3) * 3600
+			tasks[i].CompletedDate = &completedDate
+		}
+	}
+	return tasks
+}
+
+// the three passes over a fully loaded slice that Aggregator replaced,
+// kept as the baseline of BenchmarkThreePass
+func populateCompleted(allTasks []Task) CompletedDescription {
+	var completed CompletedDescription
+	for i := range allTasks {
+		if allTasks[i].CompletedDate == nil {
+			continue
+		}
+		if *allTasks[i].CompletedDate <= allTasks[i].DueDate {
+			completed.Total++
+			completed.OnTime++
+		} else {
+			completed.Total++
+			completed.Late++
+		}
+	}
+	return completed
+}
+
+func countDelayed(allTasks []Task) int {
+	var delayedCount int
+	for i := range allTasks {
+		if allTasks[i].CompletedDate == nil && allTasks[i].DueDate < time.Now().Unix() {
+			delayedCount++
+		}
+	}
+	return delayedCount
+}
+
+func populateAvailable(allTasks []Task) AvailableDescription {
+	var available AvailableDescription
+	for i := range allTasks {
+		if allTasks[i].CompletedDate == nil {
+			available.Total++
+			if time.Unix(allTasks[i].DueDate, 0).YearDay() == time.Now().YearDay() {
+				available.DueToday++
+			}
+		}
+	}
+	return available
+}
+
+const benchmarkSize = 100000
+
+func BenchmarkThreePass(b *testing.B) {
+	tasks := benchmarkTasks(benchmarkSize, time.Now())
+	for b.Loop() {
+		var report TasksReport
+		report.Completed = populateCompleted(tasks)
+		report.Delayed = countDelayed(tasks)
+		report.Available = populateAvailable(tasks)
+	}
+}
+
+func BenchmarkAggregator(b *testing.B) {
+	now := time.Now()
+	tasks := benchmarkTasks(benchmarkSize, now)
+	for b.Loop() {
+		aggregator := NewAggregatorAt(now)
+		for i := range tasks {
+			aggregator.Add(tasks[i])
+		}
+		aggregator.Report()
+	}
+}
@@ -0,0 +1,109 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"github/godspeedkil/admin-report/upstream"
+	"os"
+)
+
+// TaskSource streams tasks from wherever they are kept
+type TaskSource interface {
+	// StreamTasks hands every task to yield as it is read, stopping at the
+	// first error yield returns; count is the number read
+	StreamTasks(ctx context.Context, yield func(Task) error) (count int, err error)
+
+	// Ping checks that the tasks can be read at all
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// Source is where reports read tasks from; set from the configuration at
+// startup
+var Source TaskSource = HTTPSource{URL: TASKS_URL}
+
+// HTTPSource reads the tasks service
+type HTTPSource struct {
+	URL		string
+	Paging	upstream.Paging
+}
+
+func (s HTTPSource) StreamTasks(ctx context.Context, yield func(Task) error) (int, error) {
+	return upstream.Stream(ctx, s.URL + "/Task/tasks", s.Paging, yield)
+}
+
+func (s HTTPSource) Ping(ctx context.Context) error {
+	return upstream.Ping(ctx, s.URL)
+}
+
+func (s HTTPSource) Close() error {
+	return nil
+}
+
+// FileSource reads a JSON array or NDJSON export of the tasks service
+type FileSource struct {
+	Path	string
+}
+
+func (s FileSource) StreamTasks(ctx context.Context, yield func(Task) error) (int, error) {
+	return upstream.StreamFile(ctx, s.Path, yield)
+}
+
+func (s FileSource) Ping(ctx context.Context) error {
+	_, err := os.Stat(s.Path)
+	return err
+}
+
+func (s FileSource) Close() error {
+	return nil
+}
+
+// SQLSource reads tasks straight from a database in read-only
+// transactions. Query must return the columns of SQL_COLUMNS, in order.
+type SQLSource struct {
+	db		*sql.DB
+	query	string
+}
+
+func NewSQLSource(ctx context.Context, dsn, query string) (*SQLSource, error) {
+	db, err := upstream.OpenSQL(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLSource{db, query}, nil
+}
+
+func (s *SQLSource) StreamTasks(ctx context.Context, yield func(Task) error) (int, error) {
+	return upstream.StreamRows(ctx, s.db, s.query, scanTask, yield)
+}
+
+func (s *SQLSource) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLSource) Close() error {
+	return s.db.Close()
+}
+
+// columns an SQLSource query returns, in order; dates are unix seconds and
+// completed_date is NULL for open tasks
+const SQL_COLUMNS = "id, user_id, title, description, due_date, completed_date, remind"
+const DEFAULT_SQL_QUERY = "SELECT " + SQL_COLUMNS + " FROM tasks"
+
+func scanTask(rows *sql.Rows) (Task, error) {
+	var (
+		task			Task
+		description		sql.NullString
+		completedDate	sql.NullInt64
+		reminder		sql.NullInt64
+	)
+	err := rows.Scan(&task.TaskID, &task.UserID, &task.Title, &description,
+		&task.DueDate, &completedDate, &reminder)
+	task.Description = description.String
+	if completedDate.Valid {
+		task.CompletedDate = &completedDate.Int64
+	}
+	task.Reminder = reminder.Int64
+	return task, err
+}
@@ -0,0 +1,31 @@
+package tasks
+
+// reasons a task is left out of a report's metrics
+const (
+	REJECT_MISSING_USER_ID = "missing_user_id"
+	REJECT_MISSING_DUE_DATE = "missing_due_date"
+	REJECT_NEGATIVE_DUE_DATE = "negative_due_date"
+	REJECT_NEGATIVE_COMPLETED_DATE = "negative_completed_date"
+	REJECT_NEGATIVE_REMINDER = "negative_reminder"
+)
+
+// Validate returns every reason the task cannot be trusted, none if it is
+// valid
+func (t Task) Validate() []string {
+	var reasons []string
+	if t.UserID == "" {
+		reasons = append(reasons, REJECT_MISSING_USER_ID)
+	}
+	if t.DueDate == 0 {
+		reasons = append(reasons, REJECT_MISSING_DUE_DATE)
+	} else if t.DueDate < 0 {
+		reasons = append(reasons, REJECT_NEGATIVE_DUE_DATE)
+	}
+	if t.CompletedDate != nil && *t.CompletedDate < 0 {
+		reasons = append(reasons, REJECT_NEGATIVE_COMPLETED_DATE)
+	}
+	if t.Reminder < 0 {
+		reasons = append(reasons, REJECT_NEGATIVE_REMINDER)
+	}
+	return reasons
+}
@@ -0,0 +1,82 @@
+package upstream
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Capture keeps a copy of every payload read from upstream, byte for byte:
+// each HTTP response body, each file, and the rows of each SQL query
+type Capture interface {
+	// Payload returns where to copy the payload read from source, a URL, a
+	// file path or an SQL query, to be kept under name; the writer is closed
+	// once the payload is read, and may be written to concurrently with the
+	// writers of other payloads
+	Payload(name, source string) (io.WriteCloser, error)
+}
+
+type contextKey int
+
+const captureKey contextKey = iota
+
+// WithCapture makes Stream, StreamFile and StreamRows copy what they read
+// to capture
+func WithCapture(ctx context.Context, capture Capture) context.Context {
+	return context.WithValue(ctx, captureKey, capture)
+}
+
+// payloadWriter returns nil if ctx has no Capture
+func payloadWriter(ctx context.Context, name, source string) (io.WriteCloser, error) {
+	capture, ok := ctx.Value(captureKey).(Capture)
+	if !ok {
+		return nil, nil
+	}
+	w, err := capture.Payload(name, source)
+	if err != nil {
+		return nil, fmt.Errorf("could not capture %s: %v", source, err)
+	}
+	return w, nil
+}
+
+// capturePayload returns a reader copying body to ctx's Capture, if any, as
+// it is read, and a function reading what the decoder left of body, so that
+// the whole payload is captured, then closing the copy
+func capturePayload(ctx context.Context, name, source string, body io.Reader) (io.Reader, func() error, error) {
+	w, err := payloadWriter(ctx, name, source)
+	if err != nil || w == nil {
+		return body, func() error { return nil }, err
+	}
+	tee := io.TeeReader(body, w)
+	return tee, func() error {
+		_, err := io.Copy(io.Discard, tee)
+		return errors.Join(err, w.Close())
+	}, nil
+}
+
+// names payloads after where they come from, e.g.
+// habits.example.com/habits?limit=500&page=1
+func urlPayloadName(target string) string {
+	if _, rest, ok := strings.Cut(target, "://"); ok {
+		return rest
+	}
+	return target
+}
+
+func filePayloadName(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return path.Join("file", filepath.ToSlash(file))
+}
+
+// the query itself is kept as the payload's source
+func sqlPayloadName(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return fmt.Sprintf("sql/%x.ndjson", sum[:8])
+}
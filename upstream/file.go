@@ -0,0 +1,76 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// StreamFile decodes the records of type T kept in a local file, either a
+// JSON array or NDJSON (one record per line), and hands them to yield one
+// at a time like Stream does
+func StreamFile[T any](ctx context.Context, path string, yield func(T) error) (count int, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	body, finish, err := capturePayload(ctx, filePayloadName(path), path, file)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// an incomplete copy fails the fetch, the archive must match it
+		if finishErr := finish(); err == nil {
+			err = finishErr
+		}
+	}()
+
+	reader := bufio.NewReader(body)
+	first, err := firstByte(reader)
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	if first == '[' {
+		return decodeArray(decoder, yield)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		var record T
+		if err := decoder.Decode(&record); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("record %d: %v", count, err)
+		}
+		count++
+		if err := yield(record); err != nil {
+			return count, err
+		}
+	}
+}
+
+// firstByte returns the first byte that is not JSON whitespace, leaving it
+// unread
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, reader.UnreadByte()
+	}
+}
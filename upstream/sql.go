@@ -0,0 +1,101 @@
+package upstream
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// OpenSQL connects to a MySQL database holding upstream records, given as a
+// go-sql-driver DSN such as user:password@tcp(host:3306)/dbname
+func OpenSQL(ctx context.Context, dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("mysql: could not connect to db: %v", err)
+	}
+	return db, nil
+}
+
+// StreamRows runs query in a read-only transaction and hands each row, as
+// converted by scan, to yield like Stream does. A Capture gets the rows as
+// NDJSON, each a JSON array of the column values as the database returned
+// them, as text or null.
+func StreamRows[T any](ctx context.Context, db *sql.DB, query string,
+	scan func(*sql.Rows) (T, error), yield func(T) error) (count int, err error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not begin read-only transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("mysql: could not query: %v", err)
+	}
+	defer rows.Close()
+
+	capture, err := payloadWriter(ctx, sqlPayloadName(query), query)
+	if err != nil {
+		return 0, err
+	}
+	var encoder *json.Encoder
+	if capture != nil {
+		defer func() {
+			if closeErr := capture.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		encoder = json.NewEncoder(capture)
+	}
+
+	for rows.Next() {
+		if encoder != nil {
+			if err := captureRow(rows, encoder); err != nil {
+				return count, fmt.Errorf("mysql: could not capture record %d: %v", count, err)
+			}
+		}
+		record, err := scan(rows)
+		if err != nil {
+			return count, fmt.Errorf("mysql: could not read record %d: %v", count, err)
+		}
+		count++
+		if err := yield(record); err != nil {
+			return count, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("mysql: could not query: %v", err)
+	}
+	return count, nil
+}
+
+// captureRow encodes the current row without consuming it, scan still
+// reads it afterwards
+func captureRow(rows *sql.Rows, encoder *json.Encoder) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	raw := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+	values := make([]*string, len(columns))
+	for i, value := range raw {
+		if value != nil {
+			text := string(value)
+			values[i] = &text
+		}
+	}
+	return encoder.Encode(values)
+}
@@ -0,0 +1,246 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// how an upstream endpoint splits its records into pages
+const (
+	// one response holding the whole JSON array
+	PAGINATION_NONE = "none"
+	// ?page=N&limit=M, numbered from 1, each response a JSON array; a short
+	// page is the last one
+	PAGINATION_PAGE = "page"
+	// ?cursor=C&limit=M, each response an object with the records under
+	// "items" and the cursor of the next page under "nextCursor", empty,
+	// null or missing on the last page; null items are an empty page
+	PAGINATION_CURSOR = "cursor"
+
+	DEFAULT_PAGE_SIZE = 500
+	PAGE_PARAM = "page"
+	LIMIT_PARAM = "limit"
+	CURSOR_PARAM = "cursor"
+	ITEMS_FIELD = "items"
+	NEXT_CURSOR_FIELD = "nextCursor"
+)
+
+// Paging configures how Stream walks an endpoint
+type Paging struct {
+	Mode		string
+	PageSize	int
+}
+
+// ParsePaging validates mode, which defaults to PAGINATION_NONE, and
+// pageSize, which defaults to DEFAULT_PAGE_SIZE
+func ParsePaging(mode string, pageSize int) (Paging, error) {
+	switch mode {
+	case "":
+		mode = PAGINATION_NONE
+	case PAGINATION_NONE, PAGINATION_PAGE, PAGINATION_CURSOR:
+	default:
+		return Paging{}, fmt.Errorf("unknown pagination %q, want %s, %s or %s",
+			mode, PAGINATION_NONE, PAGINATION_PAGE, PAGINATION_CURSOR)
+	}
+	if pageSize == 0 {
+		pageSize = DEFAULT_PAGE_SIZE
+	}
+	if pageSize < 0 {
+		return Paging{}, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+	return Paging{mode, pageSize}, nil
+}
+
+// Stream fetches every record of type T from endpoint, page by page as
+// configured, and hands them to yield one at a time as they are decoded,
+// so that only one record is held in memory. It stops at the first error
+// from yield and returns it. count is the number of records decoded.
+func Stream[T any](ctx context.Context, endpoint string, paging Paging, yield func(T) error) (count int, err error) {
+	switch paging.Mode {
+	case PAGINATION_PAGE:
+		for page := 1; ; page++ {
+			// an upstream ignoring page and limit would return everything
+			// on every page, forever
+			inPage := 0
+			n, _, err := fetchPage(ctx, endpoint, url.Values{
+				PAGE_PARAM: {strconv.Itoa(page)},
+				LIMIT_PARAM: {strconv.Itoa(paging.PageSize)},
+			}, false, func(record T) error {
+				if inPage++; inPage > paging.PageSize {
+					return fmt.Errorf("upstream returned more than %d records on page %d, is it paginated?",
+						paging.PageSize, page)
+				}
+				return yield(record)
+			})
+			count += n
+			if err != nil || n == 0 || n < paging.PageSize {
+				return count, err
+			}
+		}
+	case PAGINATION_CURSOR:
+		var cursor string
+		// an upstream cycling through cursors would never reach the end
+		seen := make(map[string]bool)
+		for {
+			query := url.Values{LIMIT_PARAM: {strconv.Itoa(paging.PageSize)}}
+			if cursor != "" {
+				query.Set(CURSOR_PARAM, cursor)
+			}
+			n, next, err := fetchPage(ctx, endpoint, query, true, yield)
+			count += n
+			if err != nil || next == "" {
+				return count, err
+			}
+			if seen[next] {
+				return count, fmt.Errorf("upstream returned cursor %q twice", next)
+			}
+			seen[next] = true
+			cursor = next
+		}
+	default:
+		n, _, err := fetchPage(ctx, endpoint, nil, false, yield)
+		return n, err
+	}
+}
+
+// fetch one page, decoding a bare array or, with envelope, a cursor page
+func fetchPage[T any](ctx context.Context, endpoint string, query url.Values,
+	envelope bool, yield func(T) error) (count int, next string, err error) {
+	target := endpoint
+	if len(query) > 0 {
+		target = endpoint + "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return 0, "", fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	body, finish, err := capturePayload(ctx, urlPayloadName(target), target, resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		// an incomplete copy fails the fetch, the archive must match it
+		if finishErr := finish(); err == nil {
+			err = finishErr
+		}
+	}()
+
+	decoder := json.NewDecoder(body)
+	if envelope {
+		return decodeCursorPage(decoder, yield)
+	}
+	count, err = decodeArray(decoder, yield)
+	return count, "", err
+}
+
+// decodeArray reads a JSON array element by element
+func decodeArray[T any](decoder *json.Decoder, yield func(T) error) (count int, err error) {
+	if err := expectDelim(decoder, '['); err != nil {
+		return 0, err
+	}
+	return decodeElements(decoder, yield)
+}
+
+// decodeElements reads the elements of an array whose '[' is consumed,
+// up to and including its ']'
+func decodeElements[T any](decoder *json.Decoder, yield func(T) error) (count int, err error) {
+	for decoder.More() {
+		var record T
+		if err := decoder.Decode(&record); err != nil {
+			return count, fmt.Errorf("record %d: %v", count, err)
+		}
+		count++
+		if err := yield(record); err != nil {
+			return count, err
+		}
+	}
+	return count, expectDelim(decoder, ']')
+}
+
+// decodeCursorPage reads {"items": [...], "nextCursor": "..."}, skipping
+// any other fields
+func decodeCursorPage[T any](decoder *json.Decoder, yield func(T) error) (count int, next string, err error) {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return 0, "", err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return count, "", err
+		}
+		switch token {
+		case ITEMS_FIELD:
+			token, err := decoder.Token()
+			if err != nil {
+				return count, "", err
+			}
+			if token == nil {
+				continue
+			}
+			if token != json.Delim('[') {
+				return count, "", fmt.Errorf("%s: expected [, got %v", ITEMS_FIELD, token)
+			}
+			n, err := decodeElements(decoder, yield)
+			count += n
+			if err != nil {
+				return count, "", err
+			}
+		case NEXT_CURSOR_FIELD:
+			var cursor *string
+			if err := decoder.Decode(&cursor); err != nil {
+				return count, "", fmt.Errorf("%s: %v", NEXT_CURSOR_FIELD, err)
+			}
+			if cursor != nil {
+				next = *cursor
+			}
+		default:
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return count, "", err
+			}
+		}
+	}
+	return count, next, expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, want json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != want {
+		return fmt.Errorf("expected %v, got %v", want, token)
+	}
+	return nil
+}
+
+// Ping checks that endpoint answers HTTP requests at all; any response
+// below 500 counts as reachable
+func Ping(ctx context.Context, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	return nil
+}
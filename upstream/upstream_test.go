@@ -0,0 +1,185 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type record struct {
+	ID	int	`json:"id"`
+}
+
+// newServer answers each request with the body keyed by its query string;
+// requests for an unknown query fail the test
+func newServer(t *testing.T, bodies map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := bodies[r.URL.RawQuery]
+		if !ok {
+			t.Errorf("unexpected request %q", r.URL.RawQuery)
+			http.Error(w, "unexpected", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStream(t *testing.T) {
+	tests := []struct {
+		name		string
+		paging		Paging
+		bodies		map[string]string
+		wantCount	int
+		// substring of the error, empty if none
+		wantErr		string
+	}{
+		{
+			name:	"page mode, short last page",
+			paging:	Paging{PAGINATION_PAGE, 2},
+			bodies:	map[string]string{
+				"limit=2&page=1":	`[{"id":1},{"id":2}]`,
+				"limit=2&page=2":	`[{"id":3}]`,
+			},
+			wantCount:	3,
+		},
+		{
+			name:	"page mode, empty page",
+			paging:	Paging{PAGINATION_PAGE, 2},
+			bodies:	map[string]string{
+				"limit=2&page=1":	`[{"id":1},{"id":2}]`,
+				"limit=2&page=2":	`[]`,
+			},
+			wantCount:	2,
+		},
+		{
+			name:	"page mode, oversized page",
+			paging:	Paging{PAGINATION_PAGE, 2},
+			bodies:	map[string]string{
+				"limit=2&page=1":	`[{"id":1},{"id":2},{"id":3}]`,
+			},
+			wantCount:	3,
+			wantErr:	"more than 2 records on page 1",
+		},
+		{
+			name:	"cursor mode, null nextCursor",
+			paging:	Paging{PAGINATION_CURSOR, 2},
+			bodies:	map[string]string{
+				"limit=2":			`{"items":[{"id":1},{"id":2}],"nextCursor":"a"}`,
+				"cursor=a&limit=2":	`{"items":[{"id":3}],"nextCursor":null}`,
+			},
+			wantCount:	3,
+		},
+		{
+			name:	"cursor mode, missing nextCursor and null items",
+			paging:	Paging{PAGINATION_CURSOR, 2},
+			bodies:	map[string]string{
+				"limit=2":	`{"items":null,"total":0}`,
+			},
+			wantCount:	0,
+		},
+		{
+			name:	"cursor mode, repeated cursor",
+			paging:	Paging{PAGINATION_CURSOR, 2},
+			bodies:	map[string]string{
+				"limit=2":			`{"items":[{"id":1}],"nextCursor":"a"}`,
+				"cursor=a&limit=2":	`{"items":[{"id":2}],"nextCursor":"a"}`,
+			},
+			wantCount:	2,
+			wantErr:	`cursor "a" twice`,
+		},
+		{
+			name:	"cursor mode, cyclic cursors",
+			paging:	Paging{PAGINATION_CURSOR, 2},
+			bodies:	map[string]string{
+				"limit=2":			`{"items":[{"id":1}],"nextCursor":"a"}`,
+				"cursor=a&limit=2":	`{"items":[{"id":2}],"nextCursor":"b"}`,
+				"cursor=b&limit=2":	`{"items":[{"id":3}],"nextCursor":"a"}`,
+			},
+			wantCount:	3,
+			wantErr:	`cursor "a" twice`,
+		},
+		{
+			name:	"cursor mode, items not an array",
+			paging:	Paging{PAGINATION_CURSOR, 2},
+			bodies:	map[string]string{
+				"limit=2":	`{"items":{"id":1}}`,
+			},
+			wantErr:	"items: expected [",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, tt.bodies)
+			var ids []int
+			count, err := Stream(context.Background(), server.URL, tt.paging, func(r record) error {
+				ids = append(ids, r.ID)
+				return nil
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("err = %v, want %q", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Errorf("count = %d, want %d", count, tt.wantCount)
+			}
+			if tt.wantErr == "" && len(ids) != count {
+				t.Errorf("yielded %d records, counted %d", len(ids), count)
+			}
+		})
+	}
+}
+
+// captured keeps payloads in memory, by name
+type captured map[string]*strings.Builder
+
+func (c captured) Payload(name, source string) (io.WriteCloser, error) {
+	c[name] = &strings.Builder{}
+	return nopCloser{c[name]}, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestStreamCapture(t *testing.T) {
+	// what the decoder does not need is captured too
+	bodies := map[string]string{
+		"limit=2&page=1":	"[{\"id\":1}, {\"id\":2, \"extra\":true}]\n\n",
+		"limit=2&page=2":	"[ ]",
+	}
+	server := newServer(t, bodies)
+	capture := make(captured)
+	ctx := WithCapture(context.Background(), capture)
+	if _, err := Stream(ctx, server.URL, Paging{PAGINATION_PAGE, 2}, func(record) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("err = %v", err)
+	}
+	if len(capture) != len(bodies) {
+		t.Errorf("captured %d payloads, want %d", len(capture), len(bodies))
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	for query, body := range bodies {
+		got, ok := capture[host + "?" + query]
+		if !ok {
+			t.Errorf("page %q not captured", query)
+			continue
+		}
+		if got.String() != body {
+			t.Errorf("page %q captured as %q, want %q", query, got.String(), body)
+		}
+	}
+}